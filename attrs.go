@@ -0,0 +1,122 @@
+package mutcask
+
+import (
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// largest metadata a key takes, names and values summed up. It is kept in
+// the index entry and read with every lookup.
+const MaxMetaSize = 4 << 10
+
+// entryAttrs are set on the index entry written for a value, however the
+// value is stored. Entries of chunks go without.
+type entryAttrs struct {
+	expires  int64
+	meta     map[string]string
+	created  int64
+	modified int64
+	// sha256 of the value, computed when the put asks for it
+	sum     []byte
+	wantSum bool
+	// checked against the entry being replaced, not stored
+	cond *writeCond
+	// in versioned mode, the number of the entry and the version record of
+	// the one it replaces
+	version    uint64
+	retiredKey []byte
+	retired    []byte
+}
+
+func (a entryAttrs) apply(hlv *HintLV) {
+	hlv.Expires = a.expires
+	hlv.Meta = a.meta
+	hlv.Created = a.created
+	hlv.Modified = a.modified
+	hlv.Version = a.version
+	if hlv.Digest == nil && !hlv.Inline {
+		hlv.Sum = a.sum
+	}
+}
+
+// index adds to batch the records finding the entry of key by its attributes
+func (a entryAttrs) index(batch *leveldb.Batch, key string) {
+	if a.expires != 0 {
+		batch.Put(expiryKey(a.expires, key), nil)
+	}
+	if a.retired != nil {
+		batch.Put(a.retiredKey, a.retired)
+	}
+}
+
+// putAttrs returns the attributes of a value put with opts
+func (m *mutcask) putAttrs(opts PutOptions) (entryAttrs, error) {
+	size := 0
+	for k, v := range opts.Meta {
+		size += len(k) + len(v)
+	}
+	if size > MaxMetaSize {
+		return entryAttrs{}, ErrMetaSize
+	}
+	now := time.Now()
+	attrs := entryAttrs{modified: now.UnixNano(), wantSum: opts.Checksum}
+	if len(opts.Meta) > 0 {
+		attrs.meta = opts.Meta
+	}
+	if opts.TTL > 0 {
+		attrs.expires = now.Add(opts.TTL).UnixNano()
+		m.expiring.Store(true)
+	}
+	return attrs, nil
+}
+
+// prepare checks the condition of act against the entry it replaces,
+// carries the creation time of that entry over and in versioned mode keeps
+// it as a version. Entries of chunks are left as they are.
+func (c *Cask) prepare(act *action) error {
+	if act.attrs.modified == 0 {
+		return nil
+	}
+	act.attrs.created = act.attrs.modified
+	d, err := c.keys.Get([]byte(act.key), nil)
+	if err == leveldb.ErrNotFound {
+		if err = act.attrs.cond.check(nil, nil); err != nil || !c.versioned {
+			return err
+		}
+		return c.version(act, nil, nil)
+	}
+	if err != nil {
+		return err
+	}
+	old, err := HintLVFromBytes(d)
+	if err != nil {
+		return err
+	}
+	live := old
+	if old.expired(time.Now()) {
+		live = nil
+	}
+	if err = act.attrs.cond.check(d, live); err != nil {
+		return err
+	}
+	if live != nil && live.Created != 0 {
+		act.attrs.created = live.Created
+	}
+	if c.versioned {
+		return c.version(act, old, live)
+	}
+	return nil
+}
+
+// GetMeta returns the metadata put along with the value of key, without
+// reading the value
+func (m *mutcask) GetMeta(key string) (meta map[string]string, err error) {
+	ctx, span := m.startOp("GetMeta", key)
+	defer func() { endSpan(span, err) }()
+	hint, err := m.lookup(ctx, key)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return hint.Meta, nil
+}
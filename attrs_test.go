@@ -0,0 +1,120 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(2), InlineConf(8), ChunkConf(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	meta := map[string]string{"content-type": "text/plain", "filename": "a.txt"}
+	values := map[string][]byte{
+		"inline":  []byte("tiny"),
+		"vlog":    []byte("kept in the vlog"),
+		"chunked": bytes.Repeat([]byte("split "), 50),
+	}
+	for k, v := range values {
+		if err := m.PutWithOptions(k, v, PutOptions{Meta: meta, Checksum: true}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := m.GetMeta(k)
+		if err != nil || got["filename"] != "a.txt" || len(got) != 2 {
+			t.Fatalf("meta of %s %v: %v", k, got, err)
+		}
+		st, err := m.Stat(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum, err := m.CheckSum(k)
+		if err != nil || st.Checksum != sum {
+			t.Fatalf("checksum of %s %q, want %q: %v", k, st.Checksum, sum, err)
+		}
+		if st.Created.IsZero() || !st.Created.Equal(st.Modified) || st.Meta["content-type"] != "text/plain" {
+			t.Fatalf("stat of %s %+v", k, st)
+		}
+	}
+	big := map[string]string{"x": strings.Repeat("x", MaxMetaSize)}
+	if err := m.PutWithOptions("big", nil, PutOptions{Meta: big}); err != ErrMetaSize {
+		t.Fatalf("oversized meta: %v", err)
+	}
+	if _, err := m.GetMeta("missing"); err != ErrNotFound {
+		t.Fatalf("meta of missing key: %v", err)
+	}
+
+	// overwriting keeps the creation time and replaces the metadata
+	before, _ := m.Stat("vlog")
+	time.Sleep(time.Millisecond)
+	if err := m.PutWithOptions("vlog", []byte("rewritten"), PutOptions{TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := m.Stat("vlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.Created.Equal(before.Created) || !after.Modified.After(before.Modified) || after.Meta != nil || after.Checksum != "" {
+		t.Fatalf("stat after rewrite %+v", after)
+	}
+	if err := m.Put("vlog", []byte("rewritten")); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ = m.Stat("vlog"); !after.Created.Equal(before.Created) || !after.Modified.After(before.Modified) {
+		t.Fatalf("stat after plain put %+v", after)
+	}
+	if err := m.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := m.GetMeta("chunked"); got["filename"] != "a.txt" {
+		t.Fatalf("meta after compaction %v", got)
+	}
+
+	// dumps and incremental backups carry it
+	var dump bytes.Buffer
+	if _, err := Dump(context.Background(), m, &dump, DumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var incr bytes.Buffer
+	if _, err := m.IncrementalBackup(Manifest{}, &incr); err != nil {
+		t.Fatal(err)
+	}
+	restored := filepath.Join(tmpdirpath(t), "restored")
+	defer os.RemoveAll(filepath.Dir(restored))
+	if _, err := RestoreBackups(restored, &incr); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewMutcask(PathConf(restored), CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	loaded, err := NewMutcask(PathConf(tmpdirpath(t)), CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(loaded.cfg.Path)
+	defer loaded.Close()
+	if _, err := Restore(context.Background(), loaded, &dump, RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range []*mutcask{r, loaded} {
+		for _, k := range []string{"inline", "chunked"} {
+			if got, err := db.GetMeta(k); err != nil || got["content-type"] != "text/plain" {
+				t.Fatalf("restored meta of %s %v: %v", k, got, err)
+			}
+		}
+		if got, err := db.GetMeta("vlog"); err != nil || got != nil {
+			t.Fatalf("restored meta of vlog %v: %v", got, err)
+		}
+	}
+}
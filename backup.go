@@ -0,0 +1,362 @@
+package mutcask
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/multiformats/go-varint"
+)
+
+/**
+		magic	:	manifest	:	records ...	:	trailer
+		8		:	frame		:	frames		:	frame
+
+	frame = type byte : uvarint payload size : cbor payload, everything after
+	the manifest is compressed as the manifest says
+**/
+var backupMagic = []byte("MCBACKUP")
+
+const backupVersion = 1
+
+// compression of backup archives
+const (
+	BackupNone = ""
+	BackupGzip = "gzip"
+)
+
+const (
+	frameManifest = 'M'
+	frameRecord   = 'R'
+	frameTrailer  = 'T'
+)
+
+// largest frame Restore accepts
+const maxBackupFrameSize = 1 << 30
+
+type BackupManifest struct {
+	Version     int
+	Created     int64
+	Compression string
+	// the archive holds keys sorting after this one
+	After string
+}
+
+type backupRecord struct {
+	Key   string `cbor:"k"`
+	Value []byte `cbor:"v"`
+	// sha256 of the value
+	Sum  []byte            `cbor:"s"`
+	Meta map[string]string `cbor:"m,omitempty"`
+}
+
+// metaGetter is implemented by KVDBs which keep metadata along with values
+type metaGetter interface {
+	GetMeta(key string) (map[string]string, error)
+}
+
+// optionsPutter is implemented by KVDBs which take metadata along with values
+type optionsPutter interface {
+	PutWithOptions(key string, value []byte, opts PutOptions) error
+}
+
+// BackupTrailer closes an archive, Restore also returns it to report how far
+// it went
+type BackupTrailer struct {
+	Count uint64
+	Bytes uint64
+	// last key written, the next dump or restore can resume after it
+	LastKey string
+}
+
+type DumpOptions struct {
+	Compression string
+	// only dump keys sorting after this one
+	After string
+}
+
+type RestoreOptions struct {
+	// skip records up to and including this key
+	After string
+}
+
+// Dump writes every key of db sorting after opts.After to w in key order, so
+// an interrupted dump can be resumed from the LastKey it got to. KVDBs with
+// ListKeys are paged through, the keys of others are listed whole first.
+func Dump(ctx context.Context, db KVDB, w io.Writer, opts DumpOptions) (*BackupTrailer, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(backupMagic); err != nil {
+		return nil, err
+	}
+	err := writeFrame(bw, frameManifest, &BackupManifest{
+		Version:     backupVersion,
+		Created:     time.Now().Unix(),
+		Compression: opts.Compression,
+		After:       opts.After,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var body io.Writer = bw
+	var closeBody func() error
+	switch opts.Compression {
+	case BackupNone:
+	case BackupGzip:
+		gw := gzip.NewWriter(bw)
+		body, closeBody = gw, gw.Close
+	default:
+		return nil, fmt.Errorf("backup compression %q not support", opts.Compression)
+	}
+
+	trailer := &BackupTrailer{}
+	err = eachKey(ctx, db, opts.After, func(key string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		v, err := db.Get(key)
+		if err == ErrNotFound {
+			// deleted since the keys were listed
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("dump %s: %w", key, err)
+		}
+		rec := &backupRecord{Key: key, Value: v}
+		if mg, ok := db.(metaGetter); ok {
+			rec.Meta, err = mg.GetMeta(key)
+			if err == ErrNotFound {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("dump %s: %w", key, err)
+			}
+		}
+		sum := sha256.Sum256(v)
+		rec.Sum = sum[:]
+		if err = writeFrame(body, frameRecord, rec); err != nil {
+			return err
+		}
+		trailer.Count++
+		trailer.Bytes += uint64(len(v))
+		trailer.LastKey = key
+		return nil
+	})
+	if err != nil {
+		return trailer, err
+	}
+	if err = writeFrame(body, frameTrailer, trailer); err != nil {
+		return trailer, err
+	}
+	if closeBody != nil {
+		if err = closeBody(); err != nil {
+			return trailer, err
+		}
+	}
+	return trailer, bw.Flush()
+}
+
+// Restore puts every record of an archive written by Dump into db. A
+// truncated archive yields io.ErrUnexpectedEOF along with the trailer of
+// what was restored, so the restore can be resumed after its LastKey.
+func Restore(ctx context.Context, db KVDB, r io.Reader, opts RestoreOptions) (*BackupTrailer, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, backupMagic) {
+		return nil, ErrBackupFormat
+	}
+	manifest := &BackupManifest{}
+	if err := readFrame(br, frameManifest, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Version > backupVersion {
+		return nil, fmt.Errorf("backup version %d not support", manifest.Version)
+	}
+	var body *bufio.Reader
+	switch manifest.Compression {
+	case BackupNone:
+		body = br
+	case BackupGzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		body = bufio.NewReader(gr)
+	default:
+		return nil, fmt.Errorf("backup compression %q not support", manifest.Compression)
+	}
+
+	done := &BackupTrailer{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return done, err
+		}
+		typ, payload, err := readFramePayload(body)
+		if err != nil {
+			return done, err
+		}
+		switch typ {
+		case frameRecord:
+			rec := &backupRecord{}
+			if err := cbor.Unmarshal(payload, rec); err != nil {
+				return done, ErrBackupFormat
+			}
+			sum := sha256.Sum256(rec.Value)
+			if !bytes.Equal(sum[:], rec.Sum) {
+				return done, fmt.Errorf("restore %s: %w", rec.Key, ErrDataRotted)
+			}
+			if opts.After != "" && rec.Key <= opts.After {
+				continue
+			}
+			if op, ok := db.(optionsPutter); ok && rec.Meta != nil {
+				err = op.PutWithOptions(rec.Key, rec.Value, PutOptions{Meta: rec.Meta})
+			} else {
+				err = db.Put(rec.Key, rec.Value)
+			}
+			if err != nil {
+				return done, fmt.Errorf("restore %s: %w", rec.Key, err)
+			}
+			done.Count++
+			done.Bytes += uint64(len(rec.Value))
+			done.LastKey = rec.Key
+		case frameTrailer:
+			trailer := &BackupTrailer{}
+			if err := cbor.Unmarshal(payload, trailer); err != nil {
+				return done, ErrBackupFormat
+			}
+			if opts.After == "" && trailer.Count != done.Count {
+				return done, fmt.Errorf("backup holds %d records, restored %d: %w", trailer.Count, done.Count, ErrBackupFormat)
+			}
+			return done, nil
+		default:
+			return done, ErrBackupFormat
+		}
+	}
+}
+
+// keyLister is implemented by KVDBs which list their keys in key order
+type keyLister interface {
+	ListKeys(prefix, start string, limit int) ([]string, error)
+}
+
+// keys a dump lists at a time from KVDBs listing in key order
+const dumpPageSize = 1000
+
+// eachKey calls fn with the keys of db sorting after the given key, in key
+// order. KVDBs listing in key order are paged through, the keys of others
+// are collected and sorted.
+func eachKey(ctx context.Context, db KVDB, after string, fn func(key string) error) error {
+	if l, ok := db.(keyLister); ok {
+		for {
+			keys, err := l.ListKeys("", after, dumpPageSize)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if err = fn(key); err != nil {
+					return err
+				}
+			}
+			if len(keys) < dumpPageSize {
+				return nil
+			}
+			after = keys[len(keys)-1]
+		}
+	}
+	keys, err := sortedKeys(ctx, db, after)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedKeys lists the keys of db sorting after the given key. Not every KVDB iterates
+// in key order, sorting makes dumps resumable for all of them.
+func sortedKeys(ctx context.Context, db KVDB, after string) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	kc, err := db.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range kc {
+		if after == "" || key > after {
+			keys = append(keys, key)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func writeFrame(w io.Writer, typ byte, v interface{}) error {
+	payload, err := cbor.Marshal(v)
+	if err != nil {
+		return err
+	}
+	head := append([]byte{typ}, varint.ToUvarint(uint64(len(payload)))...)
+	if _, err = w.Write(head); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+func readFrame(br *bufio.Reader, typ byte, v interface{}) error {
+	t, payload, err := readFramePayload(br)
+	if err != nil {
+		return err
+	}
+	if t != typ {
+		return ErrBackupFormat
+	}
+	if err = cbor.Unmarshal(payload, v); err != nil {
+		return ErrBackupFormat
+	}
+	return nil
+}
+
+func readFramePayload(br *bufio.Reader) (byte, []byte, error) {
+	typ, err := br.ReadByte()
+	if err == io.EOF {
+		// archives end with a trailer
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := varint.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	if size > maxBackupFrameSize {
+		return 0, nil, ErrBackupFormat
+	}
+	payload := make([]byte, size)
+	if _, err = io.ReadFull(br, payload); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	return typ, payload, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
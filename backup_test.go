@@ -0,0 +1,98 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestDumpRestore(t *testing.T) {
+	var kvdata = []kvt{
+		{"Qmc35RPEYrW3Mj1mki6thkAjx6a1ZFkU3UYxAyFhMmngr2", []byte("124567")},
+		{"QmTwNzgUFg2kCZ47AmsKUDHwnfAhcGj6TB4mNZcott9zWc", []byte("224567")},
+		{"QmYgPV5bT37u56qePZUqLQ15JhnopaSmVx8ao39RUCoZEj", []byte("324567")},
+		{"QmfVM2KjyzYYRn3geYnqv6EWqSwRZAPpdFcgEhc61ycJRp", []byte("424567")},
+	}
+	src, err := NewMutcask(PathConf(tmpdirpath(t)), CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	for _, item := range kvdata {
+		if err := src.Put(item.Key, item.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, compression := range []string{BackupNone, BackupGzip} {
+		buf := bytes.NewBuffer(nil)
+		trailer, err := Dump(context.Background(), src, buf, DumpOptions{Compression: compression})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trailer.Count != uint64(len(kvdata)) {
+			t.Fatalf("dumped %d keys, expected %d", trailer.Count, len(kvdata))
+		}
+		archive := buf.Bytes()
+
+		// a cut archive restores a prefix and tells where to resume
+		dst := NewMemkv()
+		done, err := Restore(context.Background(), dst, bytes.NewReader(archive[:len(archive)*2/3]), RestoreOptions{})
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected unexpected eof, got %v", err)
+		}
+		_, err = Restore(context.Background(), dst, bytes.NewReader(archive), RestoreOptions{After: done.LastKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range kvdata {
+			v, err := dst.Get(item.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v, item.Value) {
+				t.Fatalf("%s should equal to %s", v, item.Value)
+			}
+		}
+	}
+}
+
+func TestDumpPages(t *testing.T) {
+	src, err := NewMutcask(PathConf(tmpdirpath(t)), CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	n := dumpPageSize + dumpPageSize/2
+	for i := 0; i < n; i++ {
+		if err := src.Put(fmt.Sprintf("key-%05d", i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the keys are paged through in order, resuming in the second page
+	after := fmt.Sprintf("key-%05d", dumpPageSize+10)
+	buf := bytes.NewBuffer(nil)
+	trailer, err := Dump(context.Background(), src, buf, DumpOptions{After: after})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := n - dumpPageSize - 11; trailer.Count != uint64(want) || trailer.LastKey != fmt.Sprintf("key-%05d", n-1) {
+		t.Fatalf("trailer %+v, want %d keys", trailer, want)
+	}
+	buf.Reset()
+	if trailer, err = Dump(context.Background(), src, buf, DumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewMemkv()
+	if _, err := Restore(context.Background(), dst, buf, RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if v, err := dst.Get(fmt.Sprintf("key-%05d", i)); err != nil || string(v) != fmt.Sprint(i) {
+			t.Fatalf("restored key %d %q: %v", i, v, err)
+		}
+	}
+}
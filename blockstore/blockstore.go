@@ -0,0 +1,179 @@
+// Package blockstore exposes a mutcask KVDB as a go-ipfs-blockstore
+// Blockstore.
+//
+// Blocks are stored under the base58 encoding of their multihash, so every
+// CID version and codec of the same content maps to one key. For sha2-256
+// that key is the CIDv0 string repos have always used for blocks. Blocks
+// stored under other CID strings are still found by their CID.
+package blockstore
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+
+	"github.com/filedag-project/mutcask"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	mh "github.com/multiformats/go-multihash"
+)
+
+var _ bstore.Blockstore = (*Blockstore)(nil)
+
+// ErrHashMismatch is returned for blocks whose data does not match their CID
+var ErrHashMismatch = bstore.ErrHashMismatch
+
+type Blockstore struct {
+	db     mutcask.KVDB
+	rehash atomic.Bool
+}
+
+// New wraps db, closing db is left to the caller
+func New(db mutcask.KVDB) *Blockstore {
+	return &Blockstore{db: db}
+}
+
+// HashOnRead makes Get check every block it reads against its CID
+func (bs *Blockstore) HashOnRead(enabled bool) {
+	bs.rehash.Store(enabled)
+}
+
+func (bs *Blockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if !c.Defined() {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	key := blockKey(c.Hash())
+	data, err := bs.db.Get(key)
+	if err == mutcask.ErrNotFound && c.String() != key {
+		data, err = bs.db.Get(c.String())
+	}
+	if err == mutcask.ErrNotFound {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bs.rehash.Load() {
+		if err = checkHash(c, data); err != nil {
+			return nil, err
+		}
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (bs *Blockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	_, err := bs.GetSize(ctx, c)
+	if _, ok := err.(ipld.ErrNotFound); ok {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (bs *Blockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	key := blockKey(c.Hash())
+	size, err := bs.db.Size(key)
+	if err == mutcask.ErrNotFound && c.String() != key {
+		size, err = bs.db.Size(c.String())
+	}
+	if err == mutcask.ErrNotFound {
+		return -1, ipld.ErrNotFound{Cid: c}
+	}
+	return size, err
+}
+
+// Put stores a block unless a block with the same multihash is stored
+// already
+func (bs *Blockstore) Put(ctx context.Context, b blocks.Block) error {
+	has, err := bs.Has(ctx, b.Cid())
+	if err != nil {
+		return err
+	}
+	if has {
+		return nil
+	}
+	if err = checkHash(b.Cid(), b.RawData()); err != nil {
+		return err
+	}
+	return bs.db.Put(blockKey(b.Cid().Hash()), b.RawData())
+}
+
+func (bs *Blockstore) PutMany(ctx context.Context, bks []blocks.Block) error {
+	for _, b := range bks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := bs.Put(ctx, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bs *Blockstore) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	key := blockKey(c.Hash())
+	if err := bs.db.Delete(key); err != nil {
+		return err
+	}
+	if c.String() != key {
+		return bs.db.Delete(c.String())
+	}
+	return nil
+}
+
+// AllKeysChan lists the blocks as raw CIDv1, the codec is not stored. Blocks
+// stored under another CID string keep their CID.
+func (bs *Blockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	kc, err := bs.db.AllKeysChan(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	out := make(chan cid.Cid)
+	go func() {
+		defer cancel()
+		defer close(out)
+		for key := range kc {
+			c, ok := keyCid(key)
+			if !ok {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- c:
+			}
+		}
+	}()
+	return out, nil
+}
+
+func blockKey(h mh.Multihash) string {
+	return h.B58String()
+}
+
+// keyCid maps a stored key back to a CID, keys which are no block keys yield
+// false
+func keyCid(key string) (cid.Cid, bool) {
+	if h, err := mh.FromB58String(key); err == nil && blockKey(h) == key {
+		return cid.NewCidV1(cid.Raw, h), true
+	}
+	c, err := cid.Decode(key)
+	if err != nil {
+		return cid.Undef, false
+	}
+	return c, true
+}
+
+func checkHash(c cid.Cid, data []byte) error {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum.Hash(), c.Hash()) {
+		return ErrHashMismatch
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package blockstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filedag-project/mutcask"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func newMutcaskBlockstore(t *testing.T) *Blockstore {
+	db, err := mutcask.NewMutcask(mutcask.PathConf(t.TempDir()), mutcask.CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return New(db)
+}
+
+func TestBlockstore(t *testing.T) {
+	ctx := context.Background()
+	bs := newMutcaskBlockstore(t)
+	b := blocks.NewBlock([]byte("some block data"))
+	if b.Cid().Version() != 0 {
+		t.Fatal("expected a CIDv0 block")
+	}
+	if err := bs.Put(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+
+	// the CIDv1 of the same content finds the block
+	v1 := cid.NewCidV1(cid.DagProtobuf, b.Cid().Hash())
+	has, err := bs.Has(ctx, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has {
+		t.Fatal("block not found by its CIDv1")
+	}
+	got, err := bs.Get(ctx, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.RawData()) != "some block data" || !got.Cid().Equals(v1) {
+		t.Fatalf("unexpected block %s", got)
+	}
+	size, err := bs.GetSize(ctx, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != len("some block data") {
+		t.Fatalf("expected size %d, got %d", len("some block data"), size)
+	}
+
+	// putting it again does not store a second copy
+	if err := bs.PutMany(ctx, []blocks.Block{b, mustBlock(t, []byte("some block data"), v1)}); err != nil {
+		t.Fatal(err)
+	}
+	kc, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []cid.Cid
+	for c := range kc {
+		keys = append(keys, c)
+	}
+	if len(keys) != 1 || keys[0].Hash().B58String() != b.Cid().Hash().B58String() {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	if err := bs.DeleteBlock(ctx, v1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.Get(ctx, b.Cid()); err == nil {
+		t.Fatal("block still present after delete")
+	} else if _, ok := err.(ipld.ErrNotFound); !ok {
+		t.Fatalf("expected ipld.ErrNotFound, got %v", err)
+	}
+}
+
+func TestHashMismatch(t *testing.T) {
+	ctx := context.Background()
+	bs := newMutcaskBlockstore(t)
+	c := blocks.NewBlock([]byte("real data")).Cid()
+	if err := bs.Put(ctx, mustBlock(t, []byte("other data"), c)); err != ErrHashMismatch {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+
+	// blocks are rehashed whatever KVDB they are read from
+	for _, db := range []mutcask.KVDB{mutcask.NewMemkv(), bs.db} {
+		mbs := New(db)
+		mbs.HashOnRead(true)
+		if err := db.Put(blockKey(c.Hash()), []byte("other data")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mbs.Get(ctx, c); err != ErrHashMismatch {
+			t.Fatalf("expected ErrHashMismatch, got %v", err)
+		}
+	}
+}
+
+func TestLegacyKeys(t *testing.T) {
+	ctx := context.Background()
+	db := mutcask.NewMemkv()
+	bs := New(db)
+	bs.HashOnRead(true)
+	data := []byte("stored by cid string")
+	c := cid.NewCidV1(cid.Raw, blocks.NewBlock(data).Cid().Hash())
+	if err := db.Put(c.String(), data); err != nil {
+		t.Fatal(err)
+	}
+	got, err := bs.Get(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.RawData()) != string(data) {
+		t.Fatalf("unexpected data %q", got.RawData())
+	}
+	kc, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range kc {
+		if !k.Equals(c) {
+			t.Fatalf("unexpected key %s", k)
+		}
+	}
+}
+
+func mustBlock(t *testing.T, data []byte, c cid.Cid) blocks.Block {
+	b, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
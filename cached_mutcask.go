@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"io"
+	"sync/atomic"
 
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/syndtr/goleveldb/leveldb/errors"
@@ -13,8 +14,10 @@ import (
 var _ KVDB = (*cachedMutcask)(nil)
 
 type cachedMutcask struct {
-	db    KVDB
-	cache *lru.ARCCache
+	db     KVDB
+	cache  *lru.ARCCache
+	hits   uint64
+	misses uint64
 }
 
 func NewCachedMutcask(cache_num int, opts ...Option) (*cachedMutcask, error) {
@@ -38,8 +41,10 @@ func (kv *cachedMutcask) Put(key string, value []byte) error {
 
 func (kv *cachedMutcask) Get(key string) ([]byte, error) {
 	if v, ok := kv.cache.Get(key); ok {
+		atomic.AddUint64(&kv.hits, 1)
 		return v.([]byte), nil
 	}
+	atomic.AddUint64(&kv.misses, 1)
 	bs, err := kv.db.Get(key)
 	if err == nil {
 		kv.cache.Add(key, bs)
@@ -79,3 +84,23 @@ func (kv *cachedMutcask) AllKeysChan(ctx context.Context) (chan string, error) {
 func (kv *cachedMutcask) Close() error {
 	return kv.db.Close()
 }
+
+// CacheStats counts the Gets answered from the cache and the ones which were
+// not
+func (kv *cachedMutcask) CacheStats() (hits, misses uint64) {
+	return atomic.LoadUint64(&kv.hits), atomic.LoadUint64(&kv.misses)
+}
+
+func (kv *cachedMutcask) Stats() (*Stats, error) {
+	if s, ok := kv.db.(interface{ Stats() (*Stats, error) }); ok {
+		return s.Stats()
+	}
+	return nil, ErrNoSupport
+}
+
+func (kv *cachedMutcask) Gauges() *Gauges {
+	if g, ok := kv.db.(interface{ Gauges() *Gauges }); ok {
+		return g.Gauges()
+	}
+	return &Gauges{}
+}
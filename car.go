@@ -0,0 +1,194 @@
+package mutcask
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-varint"
+)
+
+// largest section ImportCAR accepts, well above the block size limits of
+// ipfs tooling
+const maxCarSectionSize = 32 << 20
+
+// cbor tag of CIDs in dag-cbor
+const cidCborTag = 42
+
+type carHeader struct {
+	Roots   []cbor.Tag `cbor:"roots"`
+	Version uint64     `cbor:"version"`
+}
+
+// ExportCAR writes the blocks stored under keys to w as a CARv1 archive with
+// the given roots, nil keys exports every key of db. Keys have to be string
+// encoded CIDs. CARv1 readers reject headers without roots, so there has to
+// be at least one.
+func ExportCAR(ctx context.Context, db KVDB, w io.Writer, roots []cid.Cid, keys []string) error {
+	if len(roots) == 0 {
+		return ErrCarRoots
+	}
+	header := &carHeader{Version: 1}
+	for _, c := range roots {
+		header.Roots = append(header.Roots, cidTag(c))
+	}
+	next, stop, err := keySource(ctx, db, keys)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	bw := bufio.NewWriter(w)
+	hd, err := cbor.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if err = writeCarSection(bw, hd); err != nil {
+		return err
+	}
+	for key, ok := next(); ok; key, ok = next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c, err := cid.Decode(key)
+		if err != nil {
+			return fmt.Errorf("export %s: %w", key, err)
+		}
+		v, err := db.Get(key)
+		if err != nil {
+			return fmt.Errorf("export %s: %w", key, err)
+		}
+		if err = writeCarSection(bw, c.Bytes(), v); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportCAR stores every block of a CARv1 archive in db under its string
+// encoded CID, blocks whose multihash does not match their data are
+// rejected. It returns the number of blocks imported.
+func ImportCAR(ctx context.Context, db KVDB, r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	hd, err := readCarSection(br)
+	if err != nil {
+		return 0, err
+	}
+	header := &carHeader{}
+	if err = cbor.Unmarshal(hd, header); err != nil {
+		return 0, fmt.Errorf("invalid car header: %w", err)
+	}
+	if header.Version != 1 {
+		return 0, fmt.Errorf("car version %d not support", header.Version)
+	}
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		sec, err := readCarSection(br)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		cl, c, err := cid.CidFromBytes(sec)
+		if err != nil {
+			return n, err
+		}
+		data := sec[cl:]
+		sum, err := c.Prefix().Sum(data)
+		if err != nil {
+			return n, err
+		}
+		if !bytes.Equal(sum.Hash(), c.Hash()) {
+			return n, fmt.Errorf("import %s: %w", c, ErrDataRotted)
+		}
+		if err = db.Put(c.String(), data); err != nil {
+			return n, fmt.Errorf("import %s: %w", c, err)
+		}
+		n++
+	}
+}
+
+func (m *mutcask) Export(ctx context.Context, w io.Writer, roots []cid.Cid, keys []string) error {
+	return ExportCAR(ctx, m, w, roots, keys)
+}
+
+func (m *mutcask) Import(ctx context.Context, r io.Reader) (int, error) {
+	return ImportCAR(ctx, m, r)
+}
+
+func cidTag(c cid.Cid) cbor.Tag {
+	// dag-cbor prefixes the binary CID with the identity multibase
+	return cbor.Tag{
+		Number:  cidCborTag,
+		Content: append([]byte{0}, c.Bytes()...),
+	}
+}
+
+func writeCarSection(w io.Writer, parts ...[]byte) error {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+	if _, err := w.Write(varint.ToUvarint(uint64(size))); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readCarSection(br *bufio.Reader) ([]byte, error) {
+	size, err := varint.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 || size > maxCarSectionSize {
+		return nil, fmt.Errorf("invalid car section size %d", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// keySource iterates keys, or all keys of db when keys is nil
+func keySource(ctx context.Context, db KVDB, keys []string) (next func() (string, bool), stop func(), err error) {
+	if keys != nil {
+		i := 0
+		return func() (string, bool) {
+			if i >= len(keys) {
+				return "", false
+			}
+			i++
+			return keys[i-1], true
+		}, func() {}, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	kc, err := db.AllKeysChan(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return func() (string, bool) {
+		key, ok := <-kc
+		return key, ok
+	}, cancel, nil
+}
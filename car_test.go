@@ -0,0 +1,82 @@
+package mutcask
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestCarExportImport(t *testing.T) {
+	src := NewMemkv()
+	v0 := cid.Prefix{Version: 0, Codec: cid.DagProtobuf, MhType: mh.SHA2_256, MhLength: -1}
+	v1 := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}
+	var keys []string
+	for i, data := range [][]byte{[]byte("124567"), []byte("224567"), []byte("324567")} {
+		pref := v0
+		if i%2 == 1 {
+			pref = v1
+		}
+		c, err := pref.Sum(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, c.String())
+		if err := src.Put(c.String(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := ExportCAR(context.Background(), src, buf, nil, nil); err != ErrCarRoots {
+		t.Fatalf("export without roots: %v", err)
+	}
+	root, _ := cid.Decode(keys[1])
+	if err := ExportCAR(context.Background(), src, buf, []cid.Cid{root}, nil); err != nil {
+		t.Fatal(err)
+	}
+	car := buf.Bytes()
+	header := &carHeader{}
+	hd, err := readCarSection(bufio.NewReader(bytes.NewReader(car)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cbor.Unmarshal(hd, header); err != nil || len(header.Roots) != 1 || !bytes.Equal(header.Roots[0].Content.([]byte)[1:], root.Bytes()) {
+		t.Fatalf("header %+v: %v", header, err)
+	}
+
+	dst, err := NewMutcask(PathConf(tmpdirpath(t)), CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	n, err := dst.Import(context.Background(), bytes.NewReader(car))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(keys) {
+		t.Fatalf("imported %d blocks, expected %d", n, len(keys))
+	}
+	for _, key := range keys {
+		want, _ := src.Get(key)
+		got, err := dst.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Fatalf("%s should equal to %s", got, want)
+		}
+	}
+
+	// a flipped byte in the last block fails verification
+	car[len(car)-1] ^= 0xff
+	_, err = ImportCAR(context.Background(), NewMemkv(), bytes.NewReader(car))
+	if !errors.Is(err, ErrDataRotted) {
+		t.Fatalf("expected verification failure, got %v", err)
+	}
+}
@@ -2,15 +2,19 @@ package mutcask
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
-	"fmt"
 	"hash/crc32"
+	"log/slog"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/google/btree"
 	"github.com/syndtr/goleveldb/leveldb"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const MaxKeySize = 128
@@ -28,6 +32,53 @@ const (
 type HintLV struct {
 	VOffset uint64
 	VSize   uint32
+	// logical size of a compressed or sealed value, zero for values stored
+	// as is
+	LSize uint32 `cbor:",omitempty"`
+	// key a sealed value is sealed with, see keyring
+	EncKey uint32 `cbor:",omitempty"`
+	// set instead of the location for values stored once for several keys,
+	// see digestRecord. VOffset is then the vlog size when the entry was
+	// written, as for inline values.
+	Digest []byte `cbor:",omitempty"`
+	// set instead of the location for values split into chunks
+	Chunks *ChunkRef `cbor:",omitempty"`
+	// values up to Config.InlineSize are kept in the entry, VOffset is then
+	// the vlog size when they were written so incremental backups know
+	// which backup they belong to
+	Inline bool   `cbor:",omitempty"`
+	Value  []byte `cbor:",omitempty"`
+	// unix nanoseconds after which the key is gone, zero for keys kept
+	// until deleted
+	Expires int64 `cbor:",omitempty"`
+	// metadata put along with the value
+	Meta map[string]string `cbor:",omitempty"`
+	// unix nanoseconds the key was first and last written, zero for
+	// entries written before they were recorded
+	Created  int64 `cbor:",omitempty"`
+	Modified int64 `cbor:",omitempty"`
+	// sha256 of the value, shared values have it in Digest and inline ones
+	// go without
+	Sum []byte `cbor:",omitempty"`
+	// numbers the values of a key in versioned mode
+	Version uint64 `cbor:",omitempty"`
+	// unix nanoseconds a version was replaced or deleted at, set on version
+	// records only
+	Replaced int64 `cbor:",omitempty"`
+}
+
+// valueSize is the size of the value before compression
+func (h *HintLV) valueSize() uint64 {
+	if h.Inline {
+		return uint64(len(h.Value))
+	}
+	if h.Chunks != nil {
+		return h.Chunks.Total
+	}
+	if h.LSize > 0 || h.EncKey > 0 {
+		return uint64(h.LSize)
+	}
+	return uint64(h.VSize) - 4
 }
 
 func (h *HintLV) Bytes() (ret []byte, err error) {
@@ -49,12 +100,40 @@ func get_hint(keys *leveldb.DB, key string) (h *Hint, err error) {
 	if err != nil {
 		return nil, err
 	}
-
-	return &Hint{
-		Key:     key,
-		VOffset: hlv.VOffset,
-		VSize:   hlv.VSize,
-	}, nil
+	if hlv.expired(time.Now()) {
+		// left to the reaper
+		return nil, leveldb.ErrNotFound
+	}
+	hint := &Hint{
+		// versions are sealed and chunked under their key
+		Key:      entryKey(key),
+		VOffset:  hlv.VOffset,
+		VSize:    hlv.VSize,
+		LSize:    hlv.LSize,
+		EncKey:   hlv.EncKey,
+		Chunks:   hlv.Chunks,
+		Inline:   hlv.Inline,
+		Value:    hlv.Value,
+		Expires:  hlv.Expires,
+		Meta:     hlv.Meta,
+		Created:  hlv.Created,
+		Modified: hlv.Modified,
+		Sum:      hlv.Sum,
+		Version:  hlv.Version,
+	}
+	if hlv.Digest != nil {
+		rec, err := getDigest(keys, hlv.Digest)
+		if err != nil {
+			return nil, err
+		}
+		hint.VOffset = rec.VOffset
+		hint.VSize = rec.VSize
+		hint.LSize = rec.LSize
+		hint.EncKey = rec.EncKey
+		hint.Digest = hlv.Digest
+		hint.Cask = rec.Cask
+	}
+	return hint, nil
 }
 
 type Hint struct {
@@ -63,6 +142,57 @@ type Hint struct {
 	VOffset uint64
 	VSize   uint32
 	Deleted bool
+	// taken from HintLV, legacy hint logs have no room for them
+	LSize  uint32
+	EncKey uint32
+	// digest of a shared value and the cask storing it
+	Digest []byte
+	Cask   uint32
+	Chunks *ChunkRef
+	// value kept in the index entry
+	Inline bool
+	Value  []byte
+	// see HintLV
+	Expires  int64
+	Meta     map[string]string
+	Created  int64
+	Modified int64
+	Sum      []byte
+	Version  uint64
+	// in a read-only repo, the vlogs the index it was resolved against was
+	// loaded with
+	vlogs map[uint32]os.FileInfo
+}
+
+// caskID is the cask storing the value, keyCask unless it is shared
+func (h *Hint) caskID(keyCask uint32) uint32 {
+	if h.Digest != nil {
+		return h.Cask
+	}
+	return keyCask
+}
+
+// sealedWith is what the value is bound to when sealed, shared values are
+// sealed for all keys storing them
+func (h *Hint) sealedWith() string {
+	if h.Digest != nil {
+		return string(digestKey(h.Digest))
+	}
+	return h.Key
+}
+
+// valueSize is the size of the value before compression
+func (h *Hint) valueSize() int64 {
+	if h.Inline {
+		return int64(len(h.Value))
+	}
+	if h.Chunks != nil {
+		return int64(h.Chunks.Total)
+	}
+	if h.LSize > 0 || h.EncKey > 0 {
+		return int64(h.LSize)
+	}
+	return int64(h.VSize) - 4
 }
 
 /**
@@ -127,12 +257,8 @@ func DecodeValue(buf []byte, verify bool) (v []byte, err error) {
 		return nil, ErrValueFormat
 	}
 	if verify {
-		vcheck := binary.LittleEndian.Uint32(buf[:4])
-
-		c32 := crc32.ChecksumIEEE(buf[4:])
-		// make sure data not rotted
-		if vcheck != c32 {
-			return nil, ErrDataRotted
+		if err = VerifyValue(buf); err != nil {
+			return nil, err
 		}
 	}
 	v = make([]byte, len(buf)-4)
@@ -140,10 +266,28 @@ func DecodeValue(buf []byte, verify bool) (v []byte, err error) {
 	return
 }
 
+// VerifyValue checks the crc of an encoded value without decoding it
+func VerifyValue(buf []byte) error {
+	if len(buf) <= 4 {
+		return ErrValueFormat
+	}
+	vcheck := binary.LittleEndian.Uint32(buf[:4])
+	c32 := crc32.ChecksumIEEE(buf[4:])
+	// make sure data not rotted
+	if vcheck != c32 {
+		return ErrDataRotted
+	}
+	return nil
+}
+
 const (
 	opread = iota
 	opwrite
 	opdelete
+	opcompact
+	opexpire
+	opprune
+	opjournal
 )
 
 type action struct {
@@ -152,6 +296,24 @@ type action struct {
 	key      string
 	value    []byte
 	retvchan chan retv
+	// logical size when value is compressed, see compressValue
+	lsize uint32
+	// sha256 of the value when it is to be stored once for all keys
+	digest []byte
+	// written as is instead of appending value, for values stored elsewhere
+	entry *HintLV
+	// set on the entry written, whichever way the value is stored
+	attrs entryAttrs
+	// tells whether a key belongs to the cask being compacted
+	owns func(key string) bool
+	// keys the reaper found expired
+	expiring []expiryItem
+	// version records the retention policy drops
+	pruning []string
+	// span of the call the action serves, queued is ended once the cask
+	// goroutine picks the action up
+	ctx    context.Context
+	queued trace.Span
 }
 
 type retv struct {
@@ -167,6 +329,26 @@ type Cask struct {
 	vLogSize  uint64
 	keys      *leveldb.DB
 	path      string
+	// held for reading while a hint is resolved against the vlog, compaction
+	// takes it exclusively to swap in the rewritten vlog
+	swap sync.RWMutex
+	// bumped by every compaction, vlog offsets are only comparable within
+	// one generation
+	gen uint64
+	// actions waiting for the cask goroutine
+	pending int64
+	// live bytes to copy and copied so far by a running compaction
+	compactTotal  uint64
+	compactCopied uint64
+	log           *slog.Logger
+	// seals written values when the repo is encrypted
+	ring *keyring
+	// shared with the other casks of a writer
+	dedup *deduper
+	// keep replaced and deleted values as versions
+	versioned bool
+	// journal deletes for incremental backups, see startJournal
+	journaling bool
 	// hintLog     *os.File
 	// hintLogSize uint64
 	// keyMap      *KeyMap
@@ -179,6 +361,7 @@ func NewCask(id uint32, kdb *leveldb.DB) *Cask {
 		closeChan: cc,
 		actChan:   make(chan *action),
 		keys:      kdb,
+		log:       nopLogger.With("cask", id),
 	}
 	var once sync.Once
 	cask.close = func() {
@@ -192,6 +375,8 @@ func NewCask(id uint32, kdb *leveldb.DB) *Cask {
 			case <-cask.closeChan:
 				return
 			case act := <-cask.actChan:
+				atomic.AddInt64(&cask.pending, -1)
+				act.queued.End()
 				switch act.optype {
 				// case opread:
 				// 	cask.doread(act)
@@ -199,8 +384,20 @@ func NewCask(id uint32, kdb *leveldb.DB) *Cask {
 					cask.dodelete(act)
 				case opwrite:
 					cask.dowrite(act)
+				case opcompact:
+					cask.docompact(act)
+				case opexpire:
+					cask.doexpire(act)
+				case opprune:
+					cask.doprune(act)
+				case opjournal:
+					cask.journaling = true
+					act.retvchan <- retv{}
 				default:
-					fmt.Printf("unkown op type %d\n", act.optype)
+					cask.log.Error("unknown action", "op", act.optype)
+					if act.retvchan != nil {
+						act.retvchan <- retv{err: ErrNoSupport}
+					}
 				}
 			}
 
@@ -217,13 +414,58 @@ func (c *Cask) Close() {
 	}
 }
 
+// submit queues an action for the cask goroutine. It fails with ErrClosed
+// once the cask is closed, the action is not run then.
+func (c *Cask) submit(ctx context.Context, act *action) (err error) {
+	act.ctx = ctx
+	_, act.queued = startSpan(ctx, "queue.wait", attrCask.Int64(int64(c.id)))
+	atomic.AddInt64(&c.pending, 1)
+	select {
+	case c.actChan <- act:
+		return nil
+	case <-c.closeChan:
+		err = ErrClosed
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	atomic.AddInt64(&c.pending, -1)
+	act.queued.End()
+	return err
+}
+
 func (c *Cask) Put(key string, value []byte) (err error) {
+	return c.put(context.Background(), key, value, 0, nil, entryAttrs{})
+}
+
+func (c *Cask) put(ctx context.Context, key string, value []byte, lsize uint32, digest []byte, attrs entryAttrs) (err error) {
 	retvc := make(chan retv)
-	c.actChan <- &action{
+	if err := c.submit(ctx, &action{
 		optype:   opwrite,
 		key:      key,
 		value:    value,
+		lsize:    lsize,
+		digest:   digest,
+		attrs:    attrs,
+		retvchan: retvc,
+	}); err != nil {
+		return err
+	}
+	ret := <-retvc
+
+	return ret.err
+}
+
+// putEntry points key at a value stored under other keys, such as chunks
+func (c *Cask) putEntry(ctx context.Context, key string, hlv *HintLV, attrs entryAttrs) error {
+	retvc := make(chan retv)
+	if err := c.submit(ctx, &action{
+		optype:   opwrite,
+		key:      key,
+		entry:    hlv,
+		attrs:    attrs,
 		retvchan: retvc,
+	}); err != nil {
+		return err
 	}
 	ret := <-retvc
 
@@ -231,16 +473,22 @@ func (c *Cask) Put(key string, value []byte) (err error) {
 }
 
 func (c *Cask) Delete(key string) (err error) {
+	return c.delete(context.Background(), key)
+}
+
+func (c *Cask) delete(ctx context.Context, key string) (err error) {
 	hint, err := get_hint(c.keys, key)
 	if err != nil {
 		return nil
 	}
 	retvc := make(chan retv)
-	c.actChan <- &action{
+	if err := c.submit(ctx, &action{
 		optype:   opdelete,
 		key:      key,
 		hint:     hint,
 		retvchan: retvc,
+	}); err != nil {
+		return err
 	}
 	ret := <-retvc
 
@@ -272,7 +520,7 @@ func (c *Cask) Size(key string) (int, error) {
 	if err != nil {
 		return -1, ErrNotFound
 	}
-	return int(hint.VSize - 4), nil
+	return int(hint.valueSize()), nil
 }
 
 // func (c *Cask) doread(act *action) {
@@ -299,6 +547,16 @@ func (c *Cask) Size(key string) (int, error) {
 // 	act.retvchan <- retv{data: v}
 // }
 
+// journal records in batch a change to key that no vlog position tells of,
+// deletes and version records, for incremental backups. Repos which never
+// took one keep no journal.
+func (c *Cask) journal(batch *leveldb.Batch, key string) {
+	if !c.journaling {
+		return
+	}
+	batch.Put(deleteLogKey(c.id, c.gen, atomic.LoadUint64(&c.vLogSize), key), nil)
+}
+
 func (c *Cask) dodelete(act *action) {
 	var err error
 	defer func() {
@@ -307,7 +565,24 @@ func (c *Cask) dodelete(act *action) {
 		}
 	}()
 	// current only delete key not the data
-	c.keys.Delete([]byte(act.hint.Key), nil)
+	batch := new(leveldb.Batch)
+	batch.Delete([]byte(act.hint.Key))
+	batch.Delete(sysKey(nsQuarantine, act.hint.Key))
+	c.journal(batch, act.hint.Key)
+	released := []string{act.hint.Key}
+	if c.versioned && act.hint.Key[0] != sysPrefix {
+		var retired bool
+		if retired, err = c.retireDeleted(batch, act.hint.Key); err != nil {
+			return
+		}
+		if retired {
+			// the version holds on to the value
+			released = nil
+		}
+	}
+	if err = c.commit(batch, released...); err != nil {
+		return
+	}
 	act.retvchan <- retv{}
 }
 
@@ -319,38 +594,177 @@ func (c *Cask) dowrite(act *action) {
 		}
 	}()
 
-	var hint = &HintLV{}
+	// before anything is appended, a write that may not happen wastes no
+	// space
+	if err = c.prepare(act); err != nil {
+		return
+	}
+	if act.digest != nil && c.dedup != nil {
+		if err = c.dowriteShared(act); err != nil {
+			return
+		}
+		act.retvchan <- retv{}
+		return
+	}
 
+	hint := act.entry
+	if hint == nil {
+		if hint, err = c.appendValue(act, act.key); err != nil {
+			return
+		}
+	} else if hint.Inline {
+		hint.VOffset = atomic.LoadUint64(&c.vLogSize)
+	}
+	act.attrs.apply(hint)
+	hd, err := hint.Bytes()
+	if err != nil {
+		return
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(act.key), hd)
+	act.attrs.index(batch, act.key)
+	// a fresh value replaces whatever the scrubber found rotten
+	batch.Delete(sysKey(nsQuarantine, act.key))
+	released := []string{act.key}
+	if act.attrs.retired != nil {
+		// the version holds on to the value
+		c.journal(batch, string(act.attrs.retiredKey))
+		released = nil
+	}
+	if err = c.commit(batch, released...); err != nil {
+		return
+	}
+
+	act.retvchan <- retv{}
+}
+
+// appendValue appends the value of act to the vlog, sealed and bound to ad
+// when the repo is encrypted
+func (c *Cask) appendValue(act *action, ad string) (hint *HintLV, err error) {
 	// record file size as value offset
-	voffset := c.vLogSize
+	voffset := atomic.LoadUint64(&c.vLogSize)
+	value, lsize := act.value, act.lsize
+	var enckey uint32
+	if c.ring != nil {
+		if lsize == 0 {
+			// sealed values always start with their codec
+			value = append([]byte{byte(CompressNone)}, value...)
+			lsize = uint32(len(act.value))
+		}
+		_, span := startSpan(act.ctx, "aead.seal", attrSize.Int(len(value)))
+		value, enckey, err = c.ring.seal(ad, value)
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// encode value
-	encbytes := EncodeValue(act.value)
+	encbytes := EncodeValue(value)
 	defer vBuf.Put((*vbuffer)(&encbytes))
 	// record encoded value size
 	vsize := uint32(len(encbytes))
 	// write to vlog file
+	_, span := startSpan(act.ctx, "vlog.write", attrOffset.Int64(int64(voffset)), attrSize.Int64(int64(vsize)))
 	_, err = c.vLog.Write(encbytes)
+	endSpan(span, err)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	// operations for one cask actually did in a sync style, so there is no need to use actomic
-	// update vlog file size
-	//atomic.AddUint64(&c.vLogSize, uint64(vsize))
-	c.vLogSize += uint64(vsize)
+	// operations for one cask actually did in a sync style, atomic is only
+	// needed by readers outside the cask goroutine such as Stats
+	atomic.AddUint64(&c.vLogSize, uint64(vsize))
 
-	hint.VOffset = voffset
-	hint.VSize = vsize
+	return &HintLV{
+		VOffset: voffset,
+		VSize:   vsize,
+		LSize:   lsize,
+		EncKey:  enckey,
+	}, nil
+}
 
-	hd, err := hint.Bytes()
-	if err != nil {
-		return
+// commit writes batch, which replaces or drops the entries of keys, along
+// with the release of the shared values the entries pointed to
+func (c *Cask) commit(batch *leveldb.Batch, keys ...string) error {
+	if c.dedup == nil || !c.dedup.shared.Load() {
+		return c.keys.Write(batch, nil)
+	}
+	c.dedup.Lock()
+	defer c.dedup.Unlock()
+	if err := unref(c.keys, batch, keys...); err != nil {
+		return err
 	}
+	return c.keys.Write(batch, nil)
+}
 
-	err = c.keys.Put([]byte(act.key), hd, nil)
-	if err != nil {
-		return
+// dowriteShared points the entry of key at the value with the digest of act,
+// the value is only appended when no cask stores it yet
+func (c *Cask) dowriteShared(act *action) error {
+	var fresh *digestRecord
+	for {
+		c.dedup.Lock()
+		rec, err := getDigest(c.keys, act.digest)
+		if err == leveldb.ErrNotFound && fresh != nil {
+			rec, err = fresh, nil
+		}
+		if err == nil {
+			err = c.refShared(act, rec)
+			c.dedup.Unlock()
+			return err
+		}
+		c.dedup.Unlock()
+		if err != leveldb.ErrNotFound {
+			return err
+		}
+		// appended outside of the lock, should another cask store the value
+		// meanwhile this copy is left to compaction
+		hint, err := c.appendValue(act, string(digestKey(act.digest)))
+		if err != nil {
+			return err
+		}
+		fresh = &digestRecord{
+			Cask:    c.id,
+			VOffset: hint.VOffset,
+			VSize:   hint.VSize,
+			LSize:   hint.LSize,
+			EncKey:  hint.EncKey,
+		}
 	}
+}
 
-	act.retvchan <- retv{}
+// refShared adds the reference of key to rec, the caller holds the deduper
+func (c *Cask) refShared(act *action, rec *digestRecord) error {
+	hlv := &HintLV{Digest: act.digest, VOffset: atomic.LoadUint64(&c.vLogSize)}
+	act.attrs.apply(hlv)
+	hd, err := hlv.Bytes()
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(act.key), hd)
+	batch.Delete(sysKey(nsQuarantine, act.key))
+	act.attrs.index(batch, act.key)
+	if act.attrs.retired != nil {
+		c.journal(batch, string(act.attrs.retiredKey))
+	}
+	// a retired entry keeps its reference as a version
+	if act.attrs.retired == nil {
+		if cur, err := c.keys.Get([]byte(act.key), nil); err == nil {
+			if old, err := HintLVFromBytes(cur); err == nil && bytes.Equal(old.Digest, act.digest) {
+				// the key holds the value already, at most its attributes
+				// change
+				return c.keys.Write(batch, nil)
+			}
+		}
+		if err = unref(c.keys, batch, act.key); err != nil {
+			return err
+		}
+	}
+	rec.Refs++
+	if err = putDigest(batch, act.digest, rec); err != nil {
+		return err
+	}
+	c.dedup.shared.Store(true)
+	return c.keys.Write(batch, nil)
 }
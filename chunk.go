@@ -0,0 +1,369 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// ChunkRef is the index entry of a value split into chunks. Every chunk is
+// stored as a value of its own under an internal key made of the id, its
+// index and the key, and so lands in a cask of its own.
+type ChunkRef struct {
+	// random, a new one for every write so chunks of the value being
+	// replaced stay readable until the entry points elsewhere
+	ID    []byte
+	Count uint32
+	// size of all chunks but the last one
+	Size  uint32
+	Total uint64
+}
+
+// chunks written or read ahead at once
+const chunkParallelism = 4
+
+func chunkKey(id []byte, idx uint32, key string) string {
+	return string(sysKey(nsChunk, fmt.Sprintf("%x%08x%s", id, idx, key)))
+}
+
+func parseChunkKey(k []byte) (id []byte, idx uint32, key string, ok bool) {
+	s := sysKeySuffix(k)
+	if len(s) < 24 {
+		return nil, 0, "", false
+	}
+	id, err := hex.DecodeString(s[:16])
+	if err != nil {
+		return nil, 0, "", false
+	}
+	n, err := strconv.ParseUint(s[16:24], 16, 32)
+	if err != nil {
+		return nil, 0, "", false
+	}
+	return id, uint32(n), s[24:], true
+}
+
+func hasChunks(db *leveldb.DB) bool {
+	iter := db.NewIterator(sysRange(nsChunk), nil)
+	defer iter.Release()
+	return iter.Next()
+}
+
+// readerPutter is implemented by KVDBs which store a value as it is read
+type readerPutter interface {
+	PutReader(key string, r io.Reader) error
+}
+
+// PutReader stores what r yields under key, KVDBs which can not take a
+// reader get the value read whole
+func PutReader(db KVDB, key string, r io.Reader) error {
+	if pr, ok := db.(readerPutter); ok {
+		return pr.PutReader(key, r)
+	}
+	v, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return db.Put(key, v)
+}
+
+// PutReader stores what r yields under key. With chunking configured at
+// most a few chunks are held in memory at a time.
+func (m *mutcask) PutReader(key string, r io.Reader) (err error) {
+	ctx, span := m.startOp("PutReader", key)
+	defer func() { endSpan(span, err) }()
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	attrs, err := m.putAttrs(PutOptions{})
+	if err != nil {
+		return err
+	}
+	old := m.chunksOf(ctx, key)
+	if m.cfg.ChunkSize > 0 {
+		err = m.putChunks(ctx, key, r, attrs)
+	} else {
+		var v []byte
+		if v, err = io.ReadAll(r); err == nil {
+			err = m.put(ctx, key, v, attrs)
+		}
+	}
+	if err == nil && old != nil {
+		m.dropChunks(ctx, key, old)
+	}
+	return err
+}
+
+// putChunks stores what r yields under key, split into chunks when it is
+// larger than one. The attributes go to the entry of key.
+func (m *mutcask) putChunks(ctx context.Context, key string, r io.Reader, attrs entryAttrs) error {
+	h := sha256.New()
+	if attrs.wantSum {
+		r = io.TeeReader(r, h)
+	}
+	size := m.cfg.ChunkSize
+	cur, err := readChunk(r, size)
+	if err != nil {
+		return err
+	}
+	var next []byte
+	if len(cur) == size {
+		if next, err = readChunk(r, size); err != nil {
+			return err
+		}
+	}
+	if len(next) == 0 {
+		return m.put(ctx, key, cur, attrs)
+	}
+
+	ref := &ChunkRef{ID: make([]byte, 8), Size: uint32(size)}
+	if _, err = rand.Read(ref.ID); err != nil {
+		return err
+	}
+	// compaction leaves the chunks alone until the entry of key points at
+	// them
+	m.chunking.Store(string(ref.ID), struct{}{})
+	defer m.chunking.Delete(string(ref.ID))
+	m.chunked.Store(true)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, chunkParallelism)
+	errc := make(chan error, 1)
+	for len(cur) > 0 && err == nil {
+		select {
+		case err = <-errc:
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(idx uint32, v []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := m.put(ctx, chunkKey(ref.ID, idx, key), v, entryAttrs{}); err != nil {
+				select {
+				case errc <- err:
+				default:
+				}
+			}
+		}(ref.Count, cur)
+		ref.Count++
+		ref.Total += uint64(len(cur))
+		cur = next
+		next = nil
+		if len(cur) == size {
+			next, err = readChunk(r, size)
+		}
+	}
+	wg.Wait()
+	if err == nil {
+		select {
+		case err = <-errc:
+		default:
+		}
+	}
+	if err == nil {
+		if attrs.wantSum {
+			attrs.sum = h.Sum(nil)
+		}
+		err = m.putEntry(ctx, key, &HintLV{Chunks: ref}, attrs)
+	}
+	if err != nil {
+		// compaction drops what is left
+		m.dropChunks(ctx, key, ref)
+	}
+	return err
+}
+
+func readChunk(r io.Reader, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+// chunksOf returns the chunks the current entry of key points at, so they
+// can be dropped once it is replaced. In versioned mode they are kept for
+// the version, compaction drops them once it is pruned.
+func (m *mutcask) chunksOf(ctx context.Context, key string) *ChunkRef {
+	if !m.chunked.Load() || m.cfg.versioned() {
+		return nil
+	}
+	hint, err := m.lookup(ctx, key)
+	if err != nil {
+		return nil
+	}
+	return hint.Chunks
+}
+
+// dropChunks deletes the chunks of ref, those it misses are left to
+// compaction
+func (m *mutcask) dropChunks(ctx context.Context, key string, ref *ChunkRef) {
+	for i := uint32(0); i < ref.Count; i++ {
+		ck := chunkKey(ref.ID, i, key)
+		cask, has := m.caskMap.Get(m.fileID(ck))
+		if !has {
+			continue
+		}
+		if err := cask.delete(ctx, ck); err != nil {
+			m.cfg.Logger.Warn("dropping chunk", "key", key, "chunk", i, "err", err)
+			return
+		}
+	}
+}
+
+// dropOrphanChunks deletes the chunks owned by a cask being compacted which
+// no entry points at anymore, left by failed or interrupted writes
+func (m *mutcask) dropOrphanChunks(ctx context.Context, owns func(key string) bool) error {
+	var orphans []string
+	iter := m.keys.NewIterator(sysRange(nsChunk), nil)
+	for iter.Next() {
+		ck := string(iter.Key())
+		if !owns(ck) {
+			continue
+		}
+		id, idx, key, ok := parseChunkKey(iter.Key())
+		if !ok {
+			continue
+		}
+		// the chunk set is checked first, the entry is written before the
+		// id leaves it
+		if _, writing := m.chunking.Load(string(id)); writing {
+			continue
+		}
+		d, err := m.keys.Get([]byte(key), nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			iter.Release()
+			return err
+		}
+		if err == nil {
+			hlv, err := HintLVFromBytes(d)
+			if err == nil && hlv.Chunks != nil && bytes.Equal(hlv.Chunks.ID, id) && idx < hlv.Chunks.Count {
+				continue
+			}
+		}
+		held, err := m.versionHolds(key, id)
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		if held {
+			continue
+		}
+		orphans = append(orphans, ck)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	for _, ck := range orphans {
+		cask, has := m.caskMap.Get(m.fileID(ck))
+		if !has {
+			continue
+		}
+		if err := cask.delete(ctx, ck); err != nil {
+			return err
+		}
+	}
+	if len(orphans) > 0 {
+		m.cfg.Logger.Info("dropped orphan chunks", "chunks", len(orphans))
+	}
+	return nil
+}
+
+// RepairChunk writes chunk idx of the value of key again, with data fetched
+// elsewhere after the scrubber found it rotten
+func (m *mutcask) RepairChunk(key string, idx int, data []byte) (err error) {
+	ctx, span := m.startOp("RepairChunk", key)
+	defer func() { endSpan(span, err) }()
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	hint, err := m.lookup(ctx, key)
+	if err != nil {
+		return ErrNotFound
+	}
+	ref := hint.Chunks
+	if ref == nil || idx < 0 || idx >= int(ref.Count) {
+		return ErrChunk
+	}
+	size := uint64(ref.Size)
+	if idx == int(ref.Count)-1 {
+		size = ref.Total - uint64(ref.Size)*uint64(ref.Count-1)
+	}
+	if uint64(len(data)) != size {
+		return ErrChunk
+	}
+	return m.put(ctx, chunkKey(ref.ID, uint32(idx), key), data, entryAttrs{})
+}
+
+// readChunks copies length bytes of a chunked value starting at offset to w,
+// fetching the chunks ahead of the writes
+func (m *mutcask) readChunks(ctx context.Context, hint *Hint, w io.Writer, offset, length int64) (int, error) {
+	if length <= 0 {
+		return 0, nil
+	}
+	ref := hint.Chunks
+	size := int64(ref.Size)
+	first, last := offset/size, (offset+length-1)/size
+
+	type result struct {
+		v   []byte
+		err error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan chan result, chunkParallelism-1)
+	go func() {
+		defer close(results)
+		for i := first; i <= last; i++ {
+			rc := make(chan result, 1)
+			select {
+			case results <- rc:
+			case <-ctx.Done():
+				return
+			}
+			go func(idx uint32) {
+				v, err := m.getValue(ctx, chunkKey(ref.ID, idx, hint.Key))
+				rc <- result{v, err}
+			}(uint32(i))
+		}
+	}()
+
+	n := 0
+	pos := first * size
+	for rc := range results {
+		res := <-rc
+		if res.err != nil {
+			// ErrNotFound when the value was replaced meanwhile
+			return n, res.err
+		}
+		v := res.v
+		if offset > pos {
+			v = v[offset-pos:]
+		}
+		pos += int64(len(res.v))
+		if int64(len(v)) > length {
+			v = v[:length]
+		}
+		wn, err := w.Write(v)
+		n += wn
+		if err != nil {
+			return n, err
+		}
+		offset += int64(wn)
+		length -= int64(wn)
+	}
+	return n, nil
+}
@@ -0,0 +1,163 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChunking(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(4), ChunkConf(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	value := make([]byte, 3500)
+	rand.Read(value)
+	if err := m.Put("big", value); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PutReader("exact", bytes.NewReader(value[:1000])); err != nil {
+		t.Fatal(err)
+	}
+	st, err := m.Stat("big")
+	if err != nil || st.Chunks != 4 || st.Size != len(value) {
+		t.Fatalf("stat %+v: %v", st, err)
+	}
+	if st, _ := m.Stat("exact"); st.Chunks != 0 {
+		t.Fatal("value of one chunk split")
+	}
+
+	v, err := m.Get("big")
+	if err != nil || !bytes.Equal(v, value) {
+		t.Fatalf("get: %v", err)
+	}
+	var buf bytes.Buffer
+	if n, err := m.Read("big", &buf); err != nil || n != len(value) || !bytes.Equal(buf.Bytes(), value) {
+		t.Fatalf("read %d: %v", n, err)
+	}
+	buf.Reset()
+	if _, err := m.ReadRange("big", &buf, 900, 1200); err != nil || !bytes.Equal(buf.Bytes(), value[900:2100]) {
+		t.Fatalf("read range: %v", err)
+	}
+	sum := sha256.Sum256(value)
+	if cs, err := m.CheckSum("big"); err != nil || cs != hex.EncodeToString(sum[:]) {
+		t.Fatalf("checksum %s: %v", cs, err)
+	}
+
+	// a rotten chunk is quarantined on its own and written again
+	var rec *RecordInfo
+	for id := uint32(0); id < 4 && rec == nil; id++ {
+		recs, err := m.Records(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range recs {
+			if recs[i].Key == "big#2" {
+				rec = &recs[i]
+				fh, err := os.OpenFile(filepath.Join(dir, VLogName(id)), os.O_RDWR, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fh.WriteAt([]byte{value[2000] ^ 0xff}, int64(rec.Offset)+4)
+				fh.Close()
+			}
+		}
+	}
+	if rec == nil {
+		t.Fatal("chunk record not listed")
+	}
+	if err := m.Scrub(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	q, err := m.Quarantined()
+	if err != nil || len(q) != 1 || q[0].Key != "big" || q[0].Chunk != 2 {
+		t.Fatalf("quarantined %+v: %v", q, err)
+	}
+	if _, err := m.Get("big"); err != ErrDataRotted {
+		t.Fatalf("get rotten: %v", err)
+	}
+	if err := m.RepairChunk("big", 2, value[:10]); err != ErrChunk {
+		t.Fatalf("repair with short chunk: %v", err)
+	}
+	if err := m.RepairChunk("big", 2, value[2000:3000]); err != nil {
+		t.Fatal(err)
+	}
+	if q, _ := m.Quarantined(); len(q) != 0 {
+		t.Fatalf("still quarantined %+v", q)
+	}
+	if v, err := m.Get("big"); err != nil || !bytes.Equal(v, value) {
+		t.Fatalf("get repaired: %v", err)
+	}
+
+	// chunks go with the value, orphans with compaction
+	orphan := chunkKey([]byte("orphan!!"), 0, "gone")
+	if err := m.put(context.Background(), orphan, value[:100], entryAttrs{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Put("big", []byte("small now")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if hasChunks(m.keys) {
+		t.Fatal("chunks left")
+	}
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.VLogBytes != stats.LiveBytes {
+		t.Fatalf("not reclaimed %+v", stats)
+	}
+}
+
+func TestChunkingIncrementalBackup(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(4), ChunkConf(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	value := make([]byte, 2000)
+	rand.Read(value)
+
+	var backups []*bytes.Buffer
+	since := Manifest{}
+	for _, key := range []string{"first", "second"} {
+		if err := m.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if since, err = m.IncrementalBackup(since, buf); err != nil {
+			t.Fatal(err)
+		}
+		backups = append(backups, buf)
+	}
+
+	restored := filepath.Join(tmpdirpath(t), "restored")
+	defer os.RemoveAll(filepath.Dir(restored))
+	if _, err := RestoreBackups(restored, backups[0], backups[1]); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewMutcask(PathConf(restored), CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, key := range []string{"first", "second"} {
+		if v, err := r.Get(key); err != nil || !bytes.Equal(v, value) {
+			t.Fatalf("restored %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/filedag-project/mutcask"
+)
+
+const (
+	infoUsage    = "info"
+	verifyUsage  = "verify"
+	compactUsage = "compact [-cask id]"
+	migrateUsage = "migrate"
+	dumpUsage    = "dump-vlog [-cask id] [-preview n]"
+)
+
+func init() {
+	register(&command{name: "info", usage: infoUsage, run: runInfo})
+	register(&command{name: "verify", usage: verifyUsage, run: runVerify})
+	register(&command{name: "compact", usage: compactUsage, run: runCompact})
+	register(&command{name: "migrate", usage: migrateUsage, run: runMigrate})
+	register(&command{name: "dump-vlog", usage: dumpUsage, run: runDumpVLog})
+}
+
+func runInfo(args []string) error {
+	fs := newFlagSet("info", infoUsage)
+	rf := addRepoFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	st, err := m.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("keys:       %d\n", st.Keys)
+	fmt.Printf("vlog bytes: %d\n", st.VLogBytes)
+	fmt.Printf("live bytes: %d\n", st.LiveBytes)
+	fmt.Printf("logical:    %d\n", st.LogicalBytes)
+	fmt.Printf("dead ratio: %.2f%%\n", st.DeadRatio()*100)
+	fmt.Printf("casks:      %d\n", len(st.Casks))
+	for _, cs := range st.Casks {
+		fmt.Printf("  cask %08d: %d keys, %d vlog bytes, %d live bytes, %d logical\n", cs.ID, cs.Keys, cs.VLogBytes, cs.LiveBytes, cs.LogicalBytes)
+	}
+	return nil
+}
+
+func runVerify(args []string) error {
+	fs := newFlagSet("verify", verifyUsage)
+	rf := addRepoFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := mutcask.NewMutcask(rf.options(mutcask.OnCorruptConf(func(key string, err error) {
+		fmt.Printf("corrupt %s: %s\n", key, err)
+	}))...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.Scrub(context.Background()); err != nil {
+		return err
+	}
+	p := m.ScrubProgress()
+	fmt.Printf("verified %d keys, %d bytes, %d corrupt\n", p.Scanned, p.Bytes, p.Corrupted)
+	qs, err := m.Quarantined()
+	if err != nil {
+		return err
+	}
+	if len(qs) > 0 {
+		return fmt.Errorf("%d keys in quarantine", len(qs))
+	}
+	return nil
+}
+
+func runCompact(args []string) error {
+	fs := newFlagSet("compact", compactUsage)
+	rf := addRepoFlags(fs)
+	cask := fs.Int("cask", -1, "only compact the cask with id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	before, err := m.Stats()
+	if err != nil {
+		return err
+	}
+	if *cask >= 0 {
+		err = m.CompactCask(uint32(*cask))
+	} else {
+		err = m.Compact(context.Background())
+	}
+	if err != nil {
+		return err
+	}
+	after, err := m.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("vlog bytes: %d -> %d\n", before.VLogBytes, after.VLogBytes)
+	return nil
+}
+
+func runMigrate(args []string) error {
+	fs := newFlagSet("migrate", migrateUsage)
+	rf := addRepoFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	return m.Migrate()
+}
+
+func runDumpVLog(args []string) error {
+	fs := newFlagSet("dump-vlog", dumpUsage)
+	rf := addRepoFlags(fs)
+	cask := fs.Int("cask", -1, "only dump the vlog of the cask with id")
+	preview := fs.Int("preview", 16, "value bytes to print per record")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	st, err := m.Stats()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, cs := range st.Casks {
+		if *cask >= 0 && cs.ID != uint32(*cask) {
+			continue
+		}
+		recs, err := m.Records(cs.ID)
+		if err != nil {
+			return err
+		}
+		if err := dumpVLog(w, filepath.Join(rf.path, mutcask.VLogName(cs.ID)), recs, *preview); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpVLog decodes the records of one vlog, ranges no record refers to are
+// printed as gaps
+func dumpVLog(w io.Writer, path string, recs []mutcask.RecordInfo, preview int) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	finfo, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	size := uint64(finfo.Size())
+	fmt.Fprintf(w, "# %s %d bytes, %d records\n", path, size, len(recs))
+	end := uint64(0)
+	for _, rec := range recs {
+		if rec.Offset > end {
+			fmt.Fprintf(w, "%012d gap %d bytes\n", end, rec.Offset-end)
+		}
+		buf := make([]byte, rec.Size)
+		if _, err := fh.ReadAt(buf, int64(rec.Offset)); err != nil {
+			fmt.Fprintf(w, "%012d %s size=%d unreadable: %s\n", rec.Offset, rec.Key, rec.Size, err)
+			continue
+		}
+		status := "ok"
+		stored := binary.LittleEndian.Uint32(buf[:4])
+		if crc32.ChecksumIEEE(buf[4:]) != stored {
+			status = "ROTTED"
+		}
+		value := buf[4:]
+		if rec.EncKey > 0 {
+			fmt.Fprintf(w, "%012d %s size=%d stored=%d sealed key=%d crc=%08x %s\n", rec.Offset, rec.Key, rec.LSize, rec.Size-4, rec.EncKey, stored, status)
+		} else if rec.LSize > 0 && len(value) > 0 {
+			// the payload is not worth previewing
+			fmt.Fprintf(w, "%012d %s size=%d stored=%d codec=%s crc=%08x %s\n", rec.Offset, rec.Key, rec.LSize, rec.Size-4, mutcask.Compression(value[0]), stored, status)
+		} else {
+			if len(value) > preview {
+				value = value[:preview]
+			}
+			fmt.Fprintf(w, "%012d %s size=%d crc=%08x %s %s\n", rec.Offset, rec.Key, rec.Size-4, stored, status, strconv.Quote(string(value)))
+		}
+		if rec.Offset+uint64(rec.Size) > end {
+			end = rec.Offset + uint64(rec.Size)
+		}
+	}
+	if size > end {
+		fmt.Fprintf(w, "%012d gap %d bytes\n", end, size-end)
+	}
+	return nil
+}
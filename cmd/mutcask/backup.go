@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/filedag-project/mutcask"
+)
+
+const (
+	backupUsage  = "backup [-o file] [-gzip] [-after key]"
+	restoreUsage = "restore [-f file] [-after key]"
+)
+
+func init() {
+	register(&command{name: "backup", usage: backupUsage, run: runBackup})
+	register(&command{name: "restore", usage: restoreUsage, run: runRestore})
+}
+
+func runBackup(args []string) error {
+	fs := newFlagSet("backup", backupUsage)
+	rf := addRepoFlags(fs)
+	output := fs.String("o", "", "write the archive to file instead of stdout")
+	gz := fs.Bool("gzip", false, "compress the archive")
+	after := fs.String("after", "", "resume a dump after key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	opts := mutcask.DumpOptions{After: *after}
+	if *gz {
+		opts.Compression = mutcask.BackupGzip
+	}
+	trailer, err := mutcask.Dump(context.Background(), m, w, opts)
+	if trailer != nil {
+		fmt.Fprintf(os.Stderr, "dumped %d keys, %d bytes, last key %q\n", trailer.Count, trailer.Bytes, trailer.LastKey)
+	}
+	return err
+}
+
+func runRestore(args []string) error {
+	fs := newFlagSet("restore", restoreUsage)
+	rf := addRepoFlags(fs)
+	input := fs.String("f", "", "read the archive from file instead of stdin")
+	after := fs.String("after", "", "resume a restore after key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	done, err := mutcask.Restore(context.Background(), m, r, mutcask.RestoreOptions{After: *after})
+	if done != nil {
+		fmt.Fprintf(os.Stderr, "restored %d keys, %d bytes, last key %q\n", done.Count, done.Bytes, done.LastKey)
+	}
+	return err
+}
+
+const (
+	incrBackupUsage  = "incr-backup [-since manifest] [-manifest out] [-o file]"
+	incrRestoreUsage = "incr-restore <dir> <backup>..."
+)
+
+func init() {
+	register(&command{name: "incr-backup", usage: incrBackupUsage, run: runIncrBackup})
+	register(&command{name: "incr-restore", usage: incrRestoreUsage, run: runIncrRestore})
+}
+
+func runIncrBackup(args []string) error {
+	fs := newFlagSet("incr-backup", incrBackupUsage)
+	rf := addRepoFlags(fs)
+	sincePath := fs.String("since", "", "manifest of the previous backup, a full backup is taken without it")
+	manifestPath := fs.String("manifest", "", "write the manifest of this backup to file")
+	output := fs.String("o", "", "write the backup to file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var since mutcask.Manifest
+	if *sincePath != "" {
+		d, err := os.ReadFile(*sincePath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(d, &since); err != nil {
+			return err
+		}
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	mf, err := m.IncrementalBackup(since, w)
+	if err != nil {
+		return err
+	}
+	if *manifestPath == "" {
+		return nil
+	}
+	d, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*manifestPath, d, 0644)
+}
+
+func runIncrRestore(args []string) error {
+	fs := newFlagSet("incr-restore", incrRestoreUsage)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return fmt.Errorf("expected a target dir and backups")
+	}
+	var readers []io.Reader
+	for _, path := range fs.Args()[1:] {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	_, err := mutcask.RestoreBackups(fs.Arg(0), readers...)
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/filedag-project/mutcask"
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	exportUsage = "export -root cid... [-o file] [key...]"
+	importUsage = "import [-f file]"
+)
+
+func init() {
+	register(&command{name: "export", usage: exportUsage, run: runExport})
+	register(&command{name: "import", usage: importUsage, run: runImport})
+}
+
+func runExport(args []string) error {
+	fs := newFlagSet("export", exportUsage)
+	rf := addRepoFlags(fs)
+	output := fs.String("o", "", "write the car to file instead of stdout")
+	var roots []cid.Cid
+	fs.Func("root", "record this cid as a root of the car, repeatable", func(s string) error {
+		c, err := cid.Decode(s)
+		if err == nil {
+			roots = append(roots, c)
+		}
+		return err
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		fs.Usage()
+		return fmt.Errorf("expected a root")
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	var keys []string
+	if fs.NArg() > 0 {
+		keys = fs.Args()
+	}
+	return m.Export(context.Background(), w, roots, keys)
+}
+
+func runImport(args []string) error {
+	fs := newFlagSet("import", importUsage)
+	rf := addRepoFlags(fs)
+	input := fs.String("f", "", "read the car from file instead of stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	n, err := m.Import(context.Background(), bufio.NewReader(r))
+	fmt.Fprintf(os.Stderr, "imported %d blocks\n", n)
+	return err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/filedag-project/mutcask"
+)
+
+const fsckUsage = "fsck [-repair] [-drop-broken] [-truncate-tails] [-cask-num n] <path>"
+
+func init() {
+	register(&command{
+		name:  "fsck",
+		usage: fsckUsage,
+		run:   runFsck,
+	})
+}
+
+func runFsck(args []string) error {
+	fs := newFlagSet("fsck", fsckUsage)
+	repair := fs.Bool("repair", false, "apply all repairs")
+	dropBroken := fs.Bool("drop-broken", false, "delete index entries which point outside their vlog or fail crc")
+	truncateTails := fs.Bool("truncate-tails", false, "truncate unreferenced bytes at the end of vlogs")
+	caskNum := fs.Uint("cask-num", 0, "cask num to assume when the repo has no metadata")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one repo path")
+	}
+	report, err := mutcask.Fsck(fs.Arg(0), mutcask.FsckOptions{
+		CaskNum:       uint32(*caskNum),
+		DropBroken:    *repair || *dropBroken,
+		TruncateTails: *repair || *truncateTails,
+	})
+	if err != nil {
+		return err
+	}
+	report.WriteTo(os.Stdout)
+	if n := report.Errors(); n > 0 {
+		return fmt.Errorf("%d errors found", n)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filedag-project/mutcask"
+)
+
+const (
+	getUsage      = "get [-o file] [-version v] <key>"
+	putUsage      = "put [-f file] [-ttl d] [-meta name=value]... <key>"
+	rmUsage       = "rm <key>..."
+	lsUsage       = "ls [-prefix p] [-limit n]"
+	statUsage     = "stat <key>"
+	versionsUsage = "versions <key>"
+)
+
+func init() {
+	register(&command{name: "get", usage: getUsage, run: runGet})
+	register(&command{name: "put", usage: putUsage, run: runPut})
+	register(&command{name: "rm", usage: rmUsage, run: runRm})
+	register(&command{name: "ls", usage: lsUsage, run: runLs})
+	register(&command{name: "stat", usage: statUsage, run: runStat})
+	register(&command{name: "versions", usage: versionsUsage, run: runVersions})
+}
+
+func runGet(args []string) error {
+	fs := newFlagSet("get", getUsage)
+	rf := addRepoFlags(fs)
+	output := fs.String("o", "", "write the value to file instead of stdout")
+	version := fs.Uint64("version", 0, "get this version of the value rather than the current one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one key")
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	var v []byte
+	if *version > 0 {
+		v, err = m.GetVersion(fs.Arg(0), *version)
+	} else {
+		v, err = m.Get(fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(v)
+	return err
+}
+
+func runPut(args []string) error {
+	fs := newFlagSet("put", putUsage)
+	rf := addRepoFlags(fs)
+	input := fs.String("f", "", "read the value from file instead of stdin")
+	ttl := fs.Duration("ttl", 0, "delete the key once this has passed")
+	meta := map[string]string{}
+	fs.Func("meta", "store name=value along with the value, repeatable", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("expected name=value")
+		}
+		meta[name] = value
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one key")
+	}
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if *ttl > 0 || len(meta) > 0 {
+		v, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return m.PutWithOptions(fs.Arg(0), v, mutcask.PutOptions{TTL: *ttl, Meta: meta})
+	}
+	return m.PutReader(fs.Arg(0), r)
+}
+
+func runRm(args []string) error {
+	fs := newFlagSet("rm", rmUsage)
+	rf := addRepoFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	for _, key := range fs.Args() {
+		if err := m.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runLs(args []string) error {
+	fs := newFlagSet("ls", lsUsage)
+	rf := addRepoFlags(fs)
+	prefix := fs.String("prefix", "", "only list keys with prefix")
+	limit := fs.Int("limit", 0, "list at most n keys")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	const page = 1000
+	cursor := ""
+	listed := 0
+	for {
+		n := page
+		if *limit > 0 && *limit-listed < n {
+			n = *limit - listed
+		}
+		keys, err := m.ListKeys(*prefix, cursor, n)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			fmt.Fprintln(w, key)
+		}
+		listed += len(keys)
+		if len(keys) < n || (*limit > 0 && listed >= *limit) {
+			return nil
+		}
+		cursor = keys[len(keys)-1]
+	}
+}
+
+func runStat(args []string) error {
+	fs := newFlagSet("stat", statUsage)
+	rf := addRepoFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one key")
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	st, err := m.Stat(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("key:      %s\n", st.Key)
+	fmt.Printf("cask:     %d\n", st.Cask)
+	switch {
+	case st.Inline:
+		fmt.Printf("inline:   true\n")
+	case st.Chunks > 0:
+		fmt.Printf("chunks:   %d\n", st.Chunks)
+	default:
+		fmt.Printf("offset:   %d\n", st.Offset)
+		fmt.Printf("crc32:    %08x\n", st.CRC)
+	}
+	fmt.Printf("size:     %d\n", st.Size)
+	if st.Checksum != "" {
+		fmt.Printf("sha256:   %s\n", st.Checksum)
+	}
+	for _, t := range []struct {
+		name string
+		at   time.Time
+	}{{"created:", st.Created}, {"modified:", st.Modified}, {"expires:", st.Expires}} {
+		if !t.at.IsZero() {
+			fmt.Printf("%-9s %s\n", t.name, t.at.Format(time.RFC3339))
+		}
+	}
+	names := make([]string, 0, len(st.Meta))
+	for name := range st.Meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("meta:     %s=%s\n", name, st.Meta[name])
+	}
+	return nil
+}
+
+func runVersions(args []string) error {
+	fs := newFlagSet("versions", versionsUsage)
+	rf := addRepoFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one key")
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	vs, err := m.ListVersions(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, vi := range vs {
+		replaced := "current"
+		if !vi.Replaced.IsZero() {
+			replaced = vi.Replaced.Format(time.RFC3339)
+		}
+		fmt.Printf("%d\t%d\t%s\t%s\n", vi.Version, vi.Size, replaced, vi.Checksum)
+	}
+	return nil
+}
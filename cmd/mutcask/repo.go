@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/filedag-project/mutcask"
+)
+
+// repoFlags maps command line flags to the options of the library
+type repoFlags struct {
+	path            string
+	caskNum         uint
+	initBuf         int
+	hintBootReadNum int
+	readOnly        bool
+	logger          *slog.Logger
+	compression     mutcask.Compression
+	keys            *mutcask.KeyFile
+	cipher          string
+	dedup           bool
+	chunkSize       int
+	inlineSize      int
+	versions        int
+	versionAge      time.Duration
+}
+
+func addRepoFlags(fs *flag.FlagSet) *repoFlags {
+	rf := &repoFlags{}
+	fs.StringVar(&rf.path, "repo", os.Getenv("MUTCASK_REPO"), "repo path, defaults to $MUTCASK_REPO")
+	fs.UintVar(&rf.caskNum, "cask-num", 0, "cask num, defaults to the one recorded in the repo")
+	fs.IntVar(&rf.initBuf, "init-buf", 0, "initial size of value buffers")
+	fs.IntVar(&rf.hintBootReadNum, "hint-boot-read-num", 0, "hints read at once when migrating")
+	fs.BoolVar(&rf.readOnly, "read-only", false, "open without the repo lock, next to a running writer")
+	fs.Func("compression", "codec of values written (none, zstd, snappy), none by default", func(s string) (err error) {
+		if rf.compression, err = mutcask.ParseCompression(s); err != nil {
+			return errors.New("expected none, zstd or snappy")
+		}
+		return nil
+	})
+	fs.Func("key-file", `seal values with the last key of a file of "<id> <hex key>" lines`, func(path string) (err error) {
+		rf.keys, err = mutcask.OpenKeyFile(path)
+		return err
+	})
+	fs.StringVar(&rf.cipher, "cipher", string(mutcask.CipherAES256GCM), "cipher of values sealed with -key-file (aes-256-gcm, xchacha20-poly1305)")
+	fs.BoolVar(&rf.dedup, "dedup", false, "store identical values written once")
+	fs.IntVar(&rf.chunkSize, "chunk-size", 0, "split values larger than this into chunks of this size")
+	fs.IntVar(&rf.inlineSize, "inline-size", 0, "keep values up to this size in the index")
+	fs.IntVar(&rf.versions, "versions", 0, "keep this many versions of replaced and deleted values")
+	fs.DurationVar(&rf.versionAge, "version-age", 0, "keep versions of replaced and deleted values this long")
+	fs.Func("log-level", "log diagnostics of at least this level (debug, info, warn, error) to stderr", func(s string) error {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(s)); err != nil {
+			return err
+		}
+		rf.logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+		return nil
+	})
+	return rf
+}
+
+func (rf *repoFlags) options(extra ...mutcask.Option) []mutcask.Option {
+	opts := []mutcask.Option{mutcask.PathConf(rf.path)}
+	caskNum := uint32(rf.caskNum)
+	if caskNum == 0 {
+		if meta, err := mutcask.ReadRepoMeta(rf.path); err == nil {
+			caskNum = meta.CaskNum
+		}
+	}
+	if caskNum > 0 {
+		opts = append(opts, mutcask.CaskNumConf(int(caskNum)))
+	}
+	if rf.initBuf > 0 {
+		opts = append(opts, mutcask.InitBufConf(rf.initBuf))
+	}
+	if rf.hintBootReadNum > 0 {
+		opts = append(opts, mutcask.HintBootReadNumConf(rf.hintBootReadNum))
+	}
+	if rf.readOnly {
+		opts = append(opts, mutcask.ReadOnlyConf())
+	}
+	if rf.compression != mutcask.CompressNone {
+		opts = append(opts, mutcask.CompressionConf(rf.compression))
+	}
+	if rf.keys != nil {
+		opts = append(opts, mutcask.EncryptionConf(rf.keys, mutcask.Cipher(rf.cipher)))
+	}
+	if rf.dedup {
+		opts = append(opts, mutcask.DedupConf())
+	}
+	if rf.chunkSize > 0 {
+		opts = append(opts, mutcask.ChunkConf(rf.chunkSize))
+	}
+	if rf.inlineSize > 0 {
+		opts = append(opts, mutcask.InlineConf(rf.inlineSize))
+	}
+	if rf.versions > 0 || rf.versionAge > 0 {
+		opts = append(opts, mutcask.VersioningConf(rf.versions, rf.versionAge))
+	}
+	if rf.logger != nil {
+		opts = append(opts, mutcask.LoggerConf(rf.logger))
+	}
+	return append(opts, extra...)
+}
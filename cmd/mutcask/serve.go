@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/filedag-project/mutcask"
+	"github.com/filedag-project/mutcask/grpcapi"
+	"github.com/filedag-project/mutcask/httpapi"
+	"google.golang.org/grpc"
+)
+
+const serveUsage = "serve [-listen addr] [-grpc addr] [-max-value-size n]"
+
+// time given to running requests when the server is stopped
+const shutdownTimeout = 10 * time.Second
+
+func init() {
+	register(&command{name: "serve", usage: serveUsage, run: runServe})
+}
+
+func runServe(args []string) error {
+	fs := newFlagSet("serve", serveUsage)
+	rf := addRepoFlags(fs)
+	listen := fs.String("listen", "127.0.0.1:8080", "address to serve the http api on")
+	grpcListen := fs.String("grpc", "", "address to serve the grpc api on, none when empty")
+	maxValueSize := fs.Int64("max-value-size", httpapi.DefaultMaxValueSize, "largest value a PUT may send")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	h := httpapi.NewHandler(m)
+	h.MaxValueSize = *maxValueSize
+	srv := &http.Server{Addr: *listen, Handler: h}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 2)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	fmt.Fprintf(os.Stderr, "serving %s on %s\n", rf.path, *listen)
+	if *grpcListen != "" {
+		lis, err := net.Listen("tcp", *grpcListen)
+		if err != nil {
+			return err
+		}
+		gs := grpc.NewServer()
+		gsrv := grpcapi.NewServer(m)
+		gsrv.MaxValueSize = *maxValueSize
+		gsrv.Register(gs)
+		go func() {
+			errc <- gs.Serve(lis)
+		}()
+		defer gs.GracefulStop()
+		fmt.Fprintf(os.Stderr, "serving grpc on %s\n", *grpcListen)
+	}
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(sctx)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/filedag-project/mutcask"
+)
+
+const snapshotUsage = "snapshot <dir>"
+
+func init() {
+	register(&command{name: "snapshot", usage: snapshotUsage, run: runSnapshot})
+}
+
+func runSnapshot(args []string) error {
+	fs := newFlagSet("snapshot", snapshotUsage)
+	rf := addRepoFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one target dir")
+	}
+	m, err := mutcask.NewMutcask(rf.options()...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	return m.Snapshot(fs.Arg(0))
+}
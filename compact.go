@@ -0,0 +1,344 @@
+package mutcask
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const compactSuffix = ".compact"
+
+// Compact rewrites every vlog holding values no key refers to anymore, so
+// the space taken by overwritten and deleted values is given back. Writes to
+// a cask wait while it is being rewritten.
+func (m *mutcask) Compact(ctx context.Context) error {
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	m.maint.Lock()
+	defer m.maint.Unlock()
+	ids := m.caskIDs()
+	defer m.compactProgress(len(ids))()
+	if err := m.PruneVersions(ctx); err != nil {
+		return err
+	}
+	if err := m.dropOrphanChunks(ctx, func(string) bool { return true }); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := m.compactCask(id); err != nil {
+			return err
+		}
+		atomic.AddUint32(&m.compactDone, 1)
+	}
+	return nil
+}
+
+// compactProgress publishes the number of casks a compaction goes through,
+// the returned func clears it when the compaction is over
+func (m *mutcask) compactProgress(casks int) func() {
+	atomic.StoreUint32(&m.compactDone, 0)
+	atomic.StoreUint32(&m.compactCasks, uint32(casks))
+	return func() {
+		atomic.StoreUint32(&m.compactCasks, 0)
+		atomic.StoreUint32(&m.compactDone, 0)
+	}
+}
+
+// CompactCask rewrites the vlog of one cask
+func (m *mutcask) CompactCask(id uint32) error {
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	m.maint.Lock()
+	defer m.maint.Unlock()
+	defer m.compactProgress(1)()
+	err := m.dropOrphanChunks(context.Background(), func(key string) bool {
+		return m.fileID(key) == id
+	})
+	if err != nil {
+		return err
+	}
+	return m.compactCask(id)
+}
+
+func (m *mutcask) compactCask(id uint32) error {
+	cask, has := m.caskMap.Get(id)
+	if !has {
+		return nil
+	}
+	retvc := make(chan retv)
+	err := cask.submit(context.Background(), &action{
+		optype:   opcompact,
+		retvchan: retvc,
+		owns: func(key string) bool {
+			return m.fileID(key) == id
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ret := <-retvc
+	return ret.err
+}
+
+func (m *mutcask) caskIDs() []uint32 {
+	m.caskMap.RLock()
+	ids := make([]uint32, 0, len(m.caskMap.m))
+	for id := range m.caskMap.m {
+		ids = append(ids, id)
+	}
+	m.caskMap.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+type compactEntry struct {
+	key string
+	hlv *HintLV
+}
+
+func (c *Cask) docompact(act *action) {
+	var err error
+	defer func() {
+		if err != nil {
+			act.retvchan <- retv{err: err}
+		}
+	}()
+
+	var entries []compactEntry
+	live := make(map[uint64]uint32)
+	liveBytes := uint64(0)
+	for _, rng := range valueRanges() {
+		iter := c.keys.NewIterator(rng, nil)
+		for iter.Next() {
+			key := string(iter.Key())
+			if !act.owns(key) {
+				continue
+			}
+			var hlv *HintLV
+			hlv, err = HintLVFromBytes(iter.Value())
+			if err != nil {
+				iter.Release()
+				return
+			}
+			if hlv.Chunks != nil {
+				// the value is kept alive by its chunks
+				continue
+			}
+			entries = append(entries, compactEntry{key: key, hlv: hlv})
+			if hlv.Inline || hlv.Digest != nil {
+				// or by its digest record
+				continue
+			}
+			if _, ok := live[hlv.VOffset]; !ok {
+				live[hlv.VOffset] = hlv.VSize
+				liveBytes += uint64(hlv.VSize)
+			}
+		}
+		iter.Release()
+		if err = iter.Error(); err != nil {
+			return
+		}
+	}
+	// shared values stored here, only this goroutine appends them so none
+	// shows up until the swap
+	var digests [][]byte
+	iter := c.keys.NewIterator(sysRange(nsDigest), nil)
+	for iter.Next() {
+		rec := &digestRecord{}
+		if err = cbor.Unmarshal(iter.Value(), rec); err != nil {
+			iter.Release()
+			err = ErrHintFormat
+			return
+		}
+		if rec.Cask != c.id {
+			continue
+		}
+		digests = append(digests, []byte(sysKeySuffix(iter.Key())))
+		if _, ok := live[rec.VOffset]; !ok {
+			live[rec.VOffset] = rec.VSize
+			liveBytes += uint64(rec.VSize)
+		}
+	}
+	iter.Release()
+	if err = iter.Error(); err != nil {
+		return
+	}
+	vLogSize := atomic.LoadUint64(&c.vLogSize)
+	if liveBytes == vLogSize {
+		// nothing to reclaim
+		act.retvchan <- retv{}
+		return
+	}
+	c.log.Info("compacting", "keys", len(entries), "live", liveBytes, "size", vLogSize)
+
+	// copy live values in vlog order to keep reads sequential
+	offsets := make([]uint64, 0, len(live))
+	for off := range live {
+		offsets = append(offsets, off)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	in, err := os.Open(c.path)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	tmpPath := c.path + compactSuffix
+	out, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+	atomic.StoreUint64(&c.compactTotal, liveBytes)
+	defer func() {
+		atomic.StoreUint64(&c.compactTotal, 0)
+		atomic.StoreUint64(&c.compactCopied, 0)
+	}()
+	moved := make(map[uint64]uint64, len(offsets))
+	size := uint64(0)
+	for _, off := range offsets {
+		moved[off] = size
+		vsize := int64(live[off])
+		if _, err = io.Copy(out, io.NewSectionReader(in, int64(off), vsize)); err != nil {
+			return
+		}
+		size += uint64(vsize)
+		atomic.StoreUint64(&c.compactCopied, size)
+	}
+	if err = out.Sync(); err != nil {
+		return
+	}
+	if err = out.Close(); err != nil {
+		return
+	}
+
+	batch := new(leveldb.Batch)
+	for _, ent := range entries {
+		if ent.hlv.Inline || ent.hlv.Digest != nil {
+			// no vlog position is older than the new generation
+			ent.hlv.VOffset = 0
+		} else {
+			ent.hlv.VOffset = moved[ent.hlv.VOffset]
+		}
+		var hd []byte
+		hd, err = ent.hlv.Bytes()
+		if err != nil {
+			return
+		}
+		batch.Put([]byte(ent.key), hd)
+	}
+
+	// offsets change, deletes journaled against the old ones are of no use
+	// to incremental backups anymore
+	dlog := c.keys.NewIterator(deleteLogRange(c.id), nil)
+	for dlog.Next() {
+		batch.Delete(dlog.Key())
+	}
+	dlog.Release()
+	if err = dlog.Error(); err != nil {
+		return
+	}
+	batch.Put(caskGenKey(c.id), encodeUint64(c.gen+1))
+	// the marker lets recoverCompaction finish the swap after a crash
+	marker := compactMarker(c.id)
+	batch.Put(marker, nil)
+	if c.dedup != nil {
+		// other casks update the reference counts of the digest records,
+		// they are moved as they are by then
+		c.dedup.Lock()
+		defer c.dedup.Unlock()
+		for _, digest := range digests {
+			var rec *digestRecord
+			rec, err = getDigest(c.keys, digest)
+			if err == leveldb.ErrNotFound {
+				err = nil
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if rec.Cask != c.id {
+				continue
+			}
+			rec.VOffset = moved[rec.VOffset]
+			if err = putDigest(batch, digest, rec); err != nil {
+				return
+			}
+		}
+	}
+	c.swap.Lock()
+	defer c.swap.Unlock()
+	if err = c.keys.Write(batch, nil); err != nil {
+		return
+	}
+	c.gen++
+	if err = os.Rename(tmpPath, c.path); err != nil {
+		return
+	}
+	if err = c.keys.Delete(marker, nil); err != nil {
+		return
+	}
+	c.vLog.Close()
+	if c.vLog, err = os.OpenFile(c.path, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return
+	}
+	atomic.StoreUint64(&c.vLogSize, size)
+	c.log.Info("compacted", "size", size, "reclaimed", vLogSize-size)
+	act.retvchan <- retv{}
+}
+
+func compactMarker(id uint32) []byte {
+	return sysKey(nsCompact, VLogName(id))
+}
+
+// recoverCompaction finishes or discards compactions interrupted by a crash.
+// A rewritten vlog whose index update was committed is swapped in, otherwise
+// it is dropped and the old vlog stays valid.
+func recoverCompaction(dir string, keys *leveldb.DB, log *slog.Logger) error {
+	ids, err := vLogIDs(dir)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		path := filepath.Join(dir, VLogName(id))
+		marker := compactMarker(id)
+		committed, err := keys.Has(marker, nil)
+		if err != nil {
+			return err
+		}
+		if committed {
+			if err = os.Rename(path+compactSuffix, path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err = keys.Delete(marker, nil); err != nil {
+				return err
+			}
+			log.Info("finished interrupted compaction", "cask", id)
+			continue
+		}
+		err = os.Remove(path + compactSuffix)
+		if err == nil {
+			log.Info("dropped interrupted compaction", "cask", id)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
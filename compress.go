@@ -0,0 +1,131 @@
+package mutcask
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the codec of a record. Compressed records carry it in the
+// byte following the crc, their index entry records the logical size.
+/**
+		crc32	:	codec	:	compressed value
+		4 		: 	1		:	xxxx
+**/
+type Compression uint8
+
+const (
+	CompressNone Compression = iota
+	CompressZstd
+	CompressSnappy
+)
+
+// values shorter than this are stored as is
+const minCompressSize = 64
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressZstd:
+		return "zstd"
+	case CompressSnappy:
+		return "snappy"
+	}
+	return "unknown"
+}
+
+// ParseCompression is the inverse of String
+func ParseCompression(s string) (Compression, error) {
+	for _, c := range []Compression{CompressNone, CompressZstd, CompressSnappy} {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return CompressNone, ErrNoSupport
+}
+
+// compressValue returns the codec byte followed by v compressed with c, or
+// nil when that would not save an eighth of v
+func compressValue(v []byte, c Compression) []byte {
+	if c == CompressNone || len(v) < minCompressSize {
+		return nil
+	}
+	buf := make([]byte, 1, len(v))
+	buf[0] = byte(c)
+	switch c {
+	case CompressZstd:
+		buf = zstdEncoder.EncodeAll(v, buf)
+	case CompressSnappy:
+		// framed, so Read can decompress as it streams
+		bb := bytes.NewBuffer(buf)
+		w := snappy.NewBufferedWriter(bb)
+		if _, err := w.Write(v); err != nil {
+			return nil
+		}
+		if err := w.Close(); err != nil {
+			return nil
+		}
+		buf = bb.Bytes()
+	default:
+		return nil
+	}
+	if len(buf) > len(v)-len(v)/8 {
+		return nil
+	}
+	return buf
+}
+
+// decompressValue decodes what follows the crc of a compressed record or the
+// opened payload of a sealed one, lsize is the logical size recorded in the
+// index
+func decompressValue(payload []byte, lsize uint32) (v []byte, err error) {
+	if len(payload) == 0 {
+		return nil, ErrValueFormat
+	}
+	v = make([]byte, 0, lsize)
+	switch Compression(payload[0]) {
+	case CompressNone:
+		// sealed values carry a codec even when stored as is
+		v = append(v, payload[1:]...)
+	case CompressZstd:
+		v, err = zstdDecoder.DecodeAll(payload[1:], v)
+	case CompressSnappy:
+		bb := bytes.NewBuffer(v)
+		_, err = bb.ReadFrom(snappy.NewReader(bytes.NewReader(payload[1:])))
+		v = bb.Bytes()
+	default:
+		return nil, ErrValueFormat
+	}
+	if err != nil || len(v) != int(lsize) {
+		return nil, ErrValueFormat
+	}
+	return v, nil
+}
+
+// decompressReader streams the value of a compressed record out of r, which
+// starts past the crc. Close releases the decoder.
+func decompressReader(r io.Reader) (io.ReadCloser, error) {
+	var codec [1]byte
+	if _, err := io.ReadFull(r, codec[:]); err != nil {
+		return nil, err
+	}
+	switch Compression(codec[0]) {
+	case CompressZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case CompressSnappy:
+		return io.NopCloser(snappy.NewReader(r)), nil
+	}
+	return nil, ErrValueFormat
+}
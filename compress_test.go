@@ -0,0 +1,124 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	text := []byte(strings.Repeat(`{"name":"mutcask","kind":"metadata"},`, 200))
+	random := make([]byte, 4096)
+	rand.Read(random)
+	values := map[string][]byte{
+		"text":   text,
+		"random": random,
+		"small":  []byte("tiny"),
+	}
+
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(2), CompressionConf(CompressZstd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		if err := m.Put(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Close()
+
+	// records written with zstd stay readable once the codec changes
+	m, err = NewMutcask(PathConf(dir), CaskNumConf(2), CompressionConf(CompressSnappy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	values["snappy"] = append([]byte("snappy "), text...)
+	if err := m.Put("snappy", values["snappy"]); err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := map[string]bool{"text": true, "snappy": true}
+	check := func() {
+		for k, v := range values {
+			got, err := m.Get(k)
+			if err != nil {
+				t.Fatal(k, err)
+			}
+			if !bytes.Equal(got, v) {
+				t.Fatalf("%s: unexpected value", k)
+			}
+			size, err := m.Size(k)
+			if err != nil || size != len(v) {
+				t.Fatalf("%s: size %d, want %d (%v)", k, size, len(v), err)
+			}
+			var buf bytes.Buffer
+			if n, err := m.Read(k, &buf); err != nil || n != len(v) || !bytes.Equal(buf.Bytes(), v) {
+				t.Fatalf("%s: read %d bytes (%v)", k, n, err)
+			}
+			buf.Reset()
+			if _, err := m.ReadRange(k, &buf, 1, 2); err != nil || !bytes.Equal(buf.Bytes(), v[1:3]) {
+				t.Fatalf("%s: range read %q (%v)", k, buf.Bytes(), err)
+			}
+		}
+		st, err := m.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		logical := uint64(0)
+		for _, v := range values {
+			logical += uint64(len(v))
+		}
+		if st.LogicalBytes != logical {
+			t.Fatalf("logical bytes %d, want %d", st.LogicalBytes, logical)
+		}
+		if st.LiveBytes*2 > st.LogicalBytes {
+			t.Fatalf("values not compressed: %d live bytes for %d logical", st.LiveBytes, st.LogicalBytes)
+		}
+		for _, cs := range st.Casks {
+			recs, err := m.Records(cs.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range recs {
+				if (rec.LSize > 0) != compressed[rec.Key] {
+					t.Fatalf("%s: logical size %d recorded", rec.Key, rec.LSize)
+				}
+			}
+		}
+	}
+	check()
+
+	// compaction moves compressed records as they are
+	if err := m.Delete("random"); err != nil {
+		t.Fatal(err)
+	}
+	delete(values, "random")
+	if err := m.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+func TestCompressValue(t *testing.T) {
+	for _, c := range []Compression{CompressZstd, CompressSnappy} {
+		v := []byte(strings.Repeat(fmt.Sprintf("%s compresses well ", c), 20))
+		payload := compressValue(v, c)
+		if payload == nil || Compression(payload[0]) != c {
+			t.Fatalf("%s: not compressed", c)
+		}
+		got, err := decompressValue(payload, uint32(len(v)))
+		if err != nil || !bytes.Equal(got, v) {
+			t.Fatalf("%s: round trip failed (%v)", c, err)
+		}
+		if _, err := decompressValue(payload, uint32(len(v)+1)); err != ErrValueFormat {
+			t.Fatalf("%s: size mismatch not detected", c)
+		}
+	}
+}
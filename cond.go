@@ -0,0 +1,132 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// writeCond is checked by the cask goroutine against the entry a write
+// replaces, so no other write to the key gets in between
+type writeCond struct {
+	absent bool
+	// sha256 the value replaced has
+	sum []byte
+	// entry replaced, pinned for entries recorded without their checksum
+	entry []byte
+}
+
+// check fails with ErrConflict unless the live entry old, encoded as d, meets
+// the condition. Nil conditions always hold.
+func (wc *writeCond) check(d []byte, old *HintLV) error {
+	if wc == nil {
+		return nil
+	}
+	if wc.absent {
+		if old != nil {
+			return ErrConflict
+		}
+		return nil
+	}
+	if old == nil {
+		return ErrConflict
+	}
+	if sum := old.checksum(); sum != nil {
+		if !bytes.Equal(sum, wc.sum) {
+			return ErrConflict
+		}
+		return nil
+	}
+	if !bytes.Equal(d, wc.entry) {
+		return ErrConflict
+	}
+	return nil
+}
+
+// checksum is the sha256 of the value as recorded in the entry, nil for
+// entries written before it was
+func (h *HintLV) checksum() []byte {
+	switch {
+	case h.Digest != nil:
+		return h.Digest
+	case h.Sum != nil:
+		return h.Sum
+	case h.Inline:
+		sum := sha256.Sum256(h.Value)
+		return sum[:]
+	}
+	return nil
+}
+
+// PutIfAbsent stores value under key unless the key holds a value already,
+// in which case it fails with ErrConflict. Expired keys count as absent.
+func (m *mutcask) PutIfAbsent(key string, value []byte) error {
+	return m.PutWithOptions(key, value, PutOptions{IfAbsent: true})
+}
+
+// CompareAndSwap replaces the value of key with value if the current one has
+// the hex sha256 checksum expected, as CheckSum and Stat report it, and fails
+// with ErrConflict otherwise. The value swapped in records its checksum, so
+// the next swap need not read it.
+func (m *mutcask) CompareAndSwap(key string, expected string, value []byte) error {
+	return m.PutWithOptions(key, value, PutOptions{IfMatch: expected, Checksum: true})
+}
+
+// writeCond resolves the condition of opts against the current value of key,
+// failing early when it does not hold. The cask checks it again when writing.
+func (m *mutcask) writeCond(ctx context.Context, key string, opts PutOptions) (*writeCond, error) {
+	if opts.IfAbsent {
+		if _, err := m.lookup(ctx, key); err == nil {
+			return nil, ErrConflict
+		}
+		return &writeCond{absent: true}, nil
+	}
+	if opts.IfMatch == "" {
+		return nil, nil
+	}
+	want, err := hex.DecodeString(opts.IfMatch)
+	if err != nil || len(want) != sha256.Size {
+		return nil, ErrConflict
+	}
+	db, release := m.index()
+	d, err := db.Get([]byte(key), nil)
+	release()
+	if err == leveldb.ErrNotFound {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	hlv, err := HintLVFromBytes(d)
+	if err != nil {
+		return nil, err
+	}
+	if hlv.expired(time.Now()) {
+		return nil, ErrConflict
+	}
+	wc := &writeCond{sum: want}
+	if sum := hlv.checksum(); sum != nil {
+		if !bytes.Equal(sum, want) {
+			return nil, ErrConflict
+		}
+		return wc, nil
+	}
+	// the value is hashed and the entry pinned, a concurrent write or
+	// compaction of the key makes the swap fail
+	cur, err := m.CheckSum(key)
+	if err == ErrNotFound {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cur != hex.EncodeToString(want) {
+		return nil, ErrConflict
+	}
+	wc.entry = d
+	return wc, nil
+}
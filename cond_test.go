@@ -0,0 +1,112 @@
+package mutcask
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if err := m.PutIfAbsent("once", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	st, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PutIfAbsent("once", []byte("second")); err != ErrConflict {
+		t.Fatalf("put if absent again: %v", err)
+	}
+	if after, _ := m.Stats(); after.VLogBytes != st.VLogBytes {
+		t.Fatal("conflicting write appended")
+	}
+	if v, _ := m.Get("once"); string(v) != "first" {
+		t.Fatalf("value %q", v)
+	}
+	if err := m.PutWithTTL("lease", []byte("held"), 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if err := m.PutIfAbsent("lease", []byte("taken over")); err != nil {
+		t.Fatalf("put if absent over expired key: %v", err)
+	}
+
+	sum, err := m.CheckSum("once")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CompareAndSwap("missing", sum, []byte("x")); err != ErrConflict {
+		t.Fatalf("swap missing key: %v", err)
+	}
+	if err := m.CompareAndSwap("once", "not a checksum", []byte("x")); err != ErrConflict {
+		t.Fatalf("swap with bad checksum: %v", err)
+	}
+	if err := m.CompareAndSwap("once", sum, []byte("swapped")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CompareAndSwap("once", sum, []byte("again")); err != ErrConflict {
+		t.Fatalf("swap stale checksum: %v", err)
+	}
+
+	// entries written without a checksum are compared by value
+	if err := m.Put("plain", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := m.Stat("plain"); st.Checksum != "" {
+		t.Fatal("plain put recorded a checksum")
+	}
+	sum, _ = m.CheckSum("plain")
+	if err := m.CompareAndSwap("plain", sum, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := m.Stat("plain"); st.Checksum == "" {
+		t.Fatal("swapped value has no checksum")
+	}
+
+	// racing writers each get their increment in
+	if err := m.Put("counter", []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				for {
+					v, err := m.Get("counter")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					sum := sha256.Sum256(v)
+					n, _ := strconv.Atoi(string(v))
+					err = m.CompareAndSwap("counter", hex.EncodeToString(sum[:]), []byte(strconv.Itoa(n+1)))
+					if err == nil {
+						break
+					}
+					if err != ErrConflict {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := m.Get("counter"); string(v) != "80" {
+		t.Fatalf("counter %s", v)
+	}
+}
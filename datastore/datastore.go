@@ -0,0 +1,303 @@
+// Package datastore exposes a mutcask KVDB as a go-datastore Batching
+// datastore, so it can back IPFS and libp2p tooling.
+//
+// Datastore keys are stored as they are when they fit into MaxKeySize. Longer
+// ones are stored under a digest of the key, and the key itself is kept in
+// front of the value. The KVDB should not hold keys of other users, they
+// would show up in queries.
+package datastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"path"
+	"strings"
+
+	"github.com/filedag-project/mutcask"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-varint"
+)
+
+// prefix of the digests long keys are stored under, datastore keys always
+// start with a slash
+const longKeyPrefix = "~"
+
+// keys fetched at once when a query walks a KVDB which can list keys in order
+const listPageSize = 1024
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var _ ds.Batching = (*Datastore)(nil)
+
+type Datastore struct {
+	db mutcask.KVDB
+}
+
+// lister is implemented by KVDBs which list keys in order, mutcask does
+type lister interface {
+	ListKeys(prefix, start string, limit int) ([]string, error)
+}
+
+// New wraps db, closing the datastore closes db
+func New(db mutcask.KVDB) *Datastore {
+	return &Datastore{db: db}
+}
+
+func (d *Datastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	sk, long := storeKey(key)
+	if long {
+		value = wrapLong(key.String(), value)
+	}
+	return d.db.Put(sk, value)
+}
+
+func (d *Datastore) Delete(ctx context.Context, key ds.Key) error {
+	sk, _ := storeKey(key)
+	return d.db.Delete(sk)
+}
+
+func (d *Datastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	sk, long := storeKey(key)
+	v, err := d.db.Get(sk)
+	if err != nil {
+		return nil, dsError(err)
+	}
+	if !long {
+		return v, nil
+	}
+	k, v, err := unwrapLong(v)
+	if err != nil {
+		return nil, err
+	}
+	if k != key.String() {
+		return nil, ds.ErrNotFound
+	}
+	return v, nil
+}
+
+func (d *Datastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	_, err := d.GetSize(ctx, key)
+	if err == ds.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *Datastore) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	sk, long := storeKey(key)
+	if long {
+		v, err := d.Get(ctx, key)
+		if err != nil {
+			return -1, err
+		}
+		return len(v), nil
+	}
+	size, err := d.db.Size(sk)
+	if err != nil {
+		return -1, dsError(err)
+	}
+	return size, nil
+}
+
+// Query walks the keys below the query prefix, every other part of the query
+// is applied to the entries found
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	prefix := "/"
+	if q.Prefix != "" {
+		prefix = path.Clean("/" + q.Prefix)
+		if prefix != "/" {
+			prefix += "/"
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	next, err := d.walk(ctx, []string{prefix, longKeyPrefix})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// filters and orders may look at values even if the result does not
+	// carry them
+	withValues := !q.KeysOnly || len(q.Filters) > 0 || len(q.Orders) > 0
+	withSizes := !q.KeysOnly || q.ReturnsSizes
+	res := dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			for {
+				sk, ok, err := next()
+				if err != nil {
+					return dsq.Result{Error: err}, true
+				}
+				if !ok {
+					return dsq.Result{}, false
+				}
+				e, err := d.entry(sk, withValues, withSizes)
+				if err == ds.ErrNotFound {
+					// deleted meanwhile
+					continue
+				}
+				if err != nil {
+					return dsq.Result{Error: err}, true
+				}
+				if e == nil || !strings.HasPrefix(e.Key, prefix) {
+					continue
+				}
+				return dsq.Result{Entry: *e}, true
+			}
+		},
+		Close: func() error {
+			cancel()
+			return nil
+		},
+	})
+	res = dsq.NaiveQueryApply(q, res)
+	if q.KeysOnly && withValues {
+		res = dropValues(res)
+	}
+	return res, nil
+}
+
+// entry loads what a query result needs to know about a stored key, keys not
+// written through a datastore yield nil
+func (d *Datastore) entry(sk string, withValues, withSizes bool) (*dsq.Entry, error) {
+	switch {
+	case strings.HasPrefix(sk, "/"):
+		e := &dsq.Entry{Key: sk, Size: -1}
+		if withValues {
+			v, err := d.db.Get(sk)
+			if err != nil {
+				return nil, dsError(err)
+			}
+			e.Value, e.Size = v, len(v)
+		} else if withSizes {
+			size, err := d.db.Size(sk)
+			if err != nil {
+				return nil, dsError(err)
+			}
+			e.Size = size
+		}
+		return e, nil
+	case strings.HasPrefix(sk, longKeyPrefix):
+		v, err := d.db.Get(sk)
+		if err != nil {
+			return nil, dsError(err)
+		}
+		k, v, err := unwrapLong(v)
+		if err != nil {
+			return nil, err
+		}
+		e := &dsq.Entry{Key: k, Size: len(v)}
+		if withValues {
+			e.Value = v
+		}
+		return e, nil
+	}
+	return nil, nil
+}
+
+// walk iterates the stored keys starting with one of prefixes
+func (d *Datastore) walk(ctx context.Context, prefixes []string) (func() (string, bool, error), error) {
+	if l, ok := d.db.(lister); ok {
+		var page []string
+		start := ""
+		return func() (string, bool, error) {
+			for len(page) == 0 {
+				if len(prefixes) == 0 {
+					return "", false, nil
+				}
+				if err := ctx.Err(); err != nil {
+					return "", false, err
+				}
+				var err error
+				page, err = l.ListKeys(prefixes[0], start, listPageSize)
+				if err != nil {
+					return "", false, err
+				}
+				if len(page) < listPageSize {
+					prefixes, start = prefixes[1:], ""
+				} else {
+					start = page[len(page)-1]
+				}
+			}
+			key := page[0]
+			page = page[1:]
+			return key, true, nil
+		}, nil
+	}
+	kc, err := d.db.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func() (string, bool, error) {
+		for key := range kc {
+			for _, p := range prefixes {
+				if strings.HasPrefix(key, p) {
+					return key, true, nil
+				}
+			}
+		}
+		return "", false, ctx.Err()
+	}, nil
+}
+
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	return ds.NewBasicBatch(d), nil
+}
+
+// Sync flushes all writes of the KVDB to stable storage whatever the prefix,
+// KVDBs which can't fail with mutcask.ErrNoSupport
+func (d *Datastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return mutcask.Sync(d.db)
+}
+
+func (d *Datastore) Close() error {
+	return d.db.Close()
+}
+
+// storeKey maps a datastore key to the KVDB key it is stored under
+func storeKey(key ds.Key) (string, bool) {
+	k := key.String()
+	if len(k) <= mutcask.MaxKeySize {
+		return k, false
+	}
+	sum := sha256.Sum256([]byte(k))
+	return longKeyPrefix + b32.EncodeToString(sum[:]), true
+}
+
+/**
+	value of a long key
+		key size	:	key		:	value
+		uvarint		:	xxxx	:	xxxx
+**/
+func wrapLong(key string, value []byte) []byte {
+	buf := make([]byte, 0, varint.UvarintSize(uint64(len(key)))+len(key)+len(value))
+	buf = append(buf, varint.ToUvarint(uint64(len(key)))...)
+	buf = append(buf, key...)
+	return append(buf, value...)
+}
+
+func unwrapLong(buf []byte) (string, []byte, error) {
+	kl, n, err := varint.FromUvarint(buf)
+	if err != nil || uint64(len(buf)-n) < kl {
+		return "", nil, mutcask.ErrValueFormat
+	}
+	return string(buf[n : n+int(kl)]), buf[n+int(kl):], nil
+}
+
+func dsError(err error) error {
+	if err == mutcask.ErrNotFound {
+		return ds.ErrNotFound
+	}
+	return err
+}
+
+func dropValues(res dsq.Results) dsq.Results {
+	return dsq.ResultsFromIterator(res.Query(), dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			r, ok := res.NextSync()
+			r.Value = nil
+			return r, ok
+		},
+		Close: res.Close,
+	})
+}
@@ -0,0 +1,100 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/filedag-project/mutcask"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dstest "github.com/ipfs/go-datastore/test"
+)
+
+func newMutcaskDatastore(t *testing.T) *Datastore {
+	db, err := mutcask.NewMutcask(mutcask.PathConf(t.TempDir()), mutcask.CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(db)
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestSuite(t *testing.T) {
+	dstest.SubtestAll(t, newMutcaskDatastore(t))
+}
+
+func TestSuiteMemkv(t *testing.T) {
+	dstest.SubtestAll(t, New(mutcask.NewMemkv()))
+}
+
+func TestLongKeys(t *testing.T) {
+	ctx := context.Background()
+	d := newMutcaskDatastore(t)
+	long := ds.NewKey("/long/" + strings.Repeat("x", 2*mutcask.MaxKeySize))
+	short := ds.NewKey("/long/short")
+	if err := d.Put(ctx, long, []byte("long value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, short, []byte("short value")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := d.Get(ctx, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("long value")) {
+		t.Fatalf("unexpected value %q", v)
+	}
+	size, err := d.GetSize(ctx, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != len("long value") {
+		t.Fatalf("expected size %d, got %d", len("long value"), size)
+	}
+
+	res, err := d.Query(ctx, dsq.Query{Prefix: "/long", KeysOnly: true, Orders: []dsq.Order{dsq.OrderByKey{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Key != short.String() || entries[1].Key != long.String() {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	if entries[1].Value != nil {
+		t.Fatal("keys only query returned a value")
+	}
+
+	if err := d.Delete(ctx, long); err != nil {
+		t.Fatal(err)
+	}
+	has, err := d.Has(ctx, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Fatal("long key still present after delete")
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	d := newMutcaskDatastore(t)
+	if err := d.Put(ctx, ds.NewKey("/a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Sync(ctx, ds.NewKey("/")); err != nil {
+		t.Fatal(err)
+	}
+	// a KVDB which can't flush its writes must not be reported durable
+	bare := New(struct{ mutcask.KVDB }{mutcask.NewMemkv()})
+	if err := bare.Sync(ctx, ds.NewKey("/")); err != mutcask.ErrNoSupport {
+		t.Fatalf("expected ErrNoSupport, got %v", err)
+	}
+}
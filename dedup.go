@@ -0,0 +1,180 @@
+package mutcask
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// digestRecord locates a value stored once for all keys putting it, under
+// the sha256 of the value. Index entries of those keys only carry the digest.
+type digestRecord struct {
+	Cask    uint32
+	VOffset uint64
+	VSize   uint32
+	LSize   uint32 `cbor:",omitempty"`
+	EncKey  uint32 `cbor:",omitempty"`
+	// index entries pointing at the value, the record and with it the value
+	// go once the last one does
+	Refs uint32
+}
+
+// hintLV is the entry the value would have if it was not shared
+func (rec *digestRecord) hintLV() *HintLV {
+	return &HintLV{
+		VOffset: rec.VOffset,
+		VSize:   rec.VSize,
+		LSize:   rec.LSize,
+		EncKey:  rec.EncKey,
+	}
+}
+
+// deduper serializes the reference counting of shared values, which the
+// goroutines of all casks update
+type deduper struct {
+	sync.Mutex
+	// set once the repo holds a shared value, until then writes do not look
+	// up the entry they replace
+	shared atomic.Bool
+}
+
+func newDeduper(db *leveldb.DB) *deduper {
+	d := &deduper{}
+	d.shared.Store(hasShared(db))
+	return d
+}
+
+func hasShared(db *leveldb.DB) bool {
+	iter := db.NewIterator(sysRange(nsDigest), nil)
+	defer iter.Release()
+	return iter.Next()
+}
+
+func digestKey(digest []byte) []byte {
+	return sysKey(nsDigest, string(digest))
+}
+
+// indexGetter reads the index or a snapshot of it
+type indexGetter interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+}
+
+func getDigest(db indexGetter, digest []byte) (*digestRecord, error) {
+	d, err := db.Get(digestKey(digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	return digestFromBytes(d)
+}
+
+func digestFromBytes(d []byte) (*digestRecord, error) {
+	rec := &digestRecord{}
+	if err := cbor.Unmarshal(d, rec); err != nil {
+		return nil, ErrHintFormat
+	}
+	return rec, nil
+}
+
+func putDigest(batch *leveldb.Batch, digest []byte, rec *digestRecord) error {
+	d, err := cbor.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	batch.Put(digestKey(digest), d)
+	return nil
+}
+
+// unref adds to batch the release of the shared values the current entries
+// of keys point to, if any. The caller holds the deduper.
+func unref(db *leveldb.DB, batch *leveldb.Batch, keys ...string) error {
+	recs := make(map[string]*digestRecord)
+	for _, key := range keys {
+		d, err := db.Get([]byte(key), nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		hlv, err := HintLVFromBytes(d)
+		if err != nil {
+			return err
+		}
+		if hlv.Digest == nil {
+			continue
+		}
+		rec, ok := recs[string(hlv.Digest)]
+		if !ok {
+			rec, err = getDigest(db, hlv.Digest)
+			if err == leveldb.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			recs[string(hlv.Digest)] = rec
+		}
+		rec.Refs--
+	}
+	for digest, rec := range recs {
+		if rec.Refs == 0 {
+			batch.Delete(digestKey([]byte(digest)))
+			continue
+		}
+		if err := putDigest(batch, []byte(digest), rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recountRefs sets the reference counts of the digest records to the index
+// entries pointing at them, records no entry points at are dropped. Restored
+// backups carry the records but not their counts.
+func recountRefs(db *leveldb.DB) error {
+	iter := db.NewIterator(sysRange(nsDigest), nil)
+	shared := iter.Next()
+	iter.Release()
+	if !shared {
+		return iter.Error()
+	}
+	refs := make(map[string]uint32)
+	for _, rng := range valueRanges() {
+		iter := db.NewIterator(rng, nil)
+		for iter.Next() {
+			if hlv, err := HintLVFromBytes(iter.Value()); err == nil && hlv.Digest != nil {
+				refs[string(hlv.Digest)]++
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	batch := new(leveldb.Batch)
+	iter = db.NewIterator(sysRange(nsDigest), nil)
+	defer iter.Release()
+	for iter.Next() {
+		digest := sysKeySuffix(iter.Key())
+		rec, err := digestFromBytes(iter.Value())
+		if err != nil {
+			return err
+		}
+		switch n := refs[digest]; {
+		case n == 0:
+			batch.Delete(iter.Key())
+		case n != rec.Refs:
+			rec.Refs = n
+			if err = putDigest(batch, []byte(digest), rec); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return db.Write(batch, nil)
+}
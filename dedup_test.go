@@ -0,0 +1,203 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestDedup(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	kf := writeKeyFile(t, filepath.Join(t.TempDir(), "keys"), "k1")
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(4), DedupConf(), CompressionConf(CompressZstd), EncryptionConf(kf, CipherAES256GCM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	shared := []byte(strings.Repeat("the same block in many files ", 100))
+	keys := make([]string, 16)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("file-%d", i)
+		if err := m.Put(keys[i], shared); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vlogBytes := func() uint64 {
+		st, err := m.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.VLogBytes
+	}
+	stored := vlogBytes()
+	st, _ := m.Stats()
+	if st.LiveBytes != stored || st.LogicalBytes != uint64(len(shared)*len(keys)) {
+		t.Fatalf("stats %+v", st)
+	}
+	for _, k := range keys {
+		v, err := m.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v, shared) {
+			t.Fatalf("value of %s", k)
+		}
+		var buf bytes.Buffer
+		if _, err := m.Read(k, &buf); err != nil || !bytes.Equal(buf.Bytes(), shared) {
+			t.Fatalf("read %s: %v", k, err)
+		}
+	}
+
+	// putting it again or overwriting with it appends nothing
+	if err := m.Put(keys[0], shared); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Put("other", []byte("a value of its own")); err != nil {
+		t.Fatal(err)
+	}
+	stored = vlogBytes()
+	if err := m.Put("other", shared); err != nil {
+		t.Fatal(err)
+	}
+	if vlogBytes() != stored {
+		t.Fatal("shared value appended again")
+	}
+	keys = append(keys, "other")
+
+	// the value goes with the last key referring to it
+	for _, k := range keys[1:] {
+		if err := m.Delete(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	v, err := m.Get(keys[0])
+	if err != nil || !bytes.Equal(v, shared) {
+		t.Fatalf("get after compaction: %v", err)
+	}
+	if err := m.Put(keys[0], []byte("overwritten")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(shared)
+	if _, err := getDigest(m.keys, digest[:]); err != leveldb.ErrNotFound {
+		t.Fatalf("digest record left: %v", err)
+	}
+	st, _ = m.Stats()
+	if st.VLogBytes != st.LiveBytes {
+		t.Fatalf("not reclaimed %+v", st)
+	}
+	rc, err := m.Get(keys[0])
+	if err != nil || string(rc) != "overwritten" {
+		t.Fatalf("get %q: %v", rc, err)
+	}
+	if _, err := m.ReadRange(keys[0], io.Discard, 4, 3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDedupIncrementalBackup(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(4), DedupConf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	restored := filepath.Join(tmpdirpath(t), "restored")
+	defer os.RemoveAll(filepath.Dir(restored))
+
+	one, two := []byte("shared value one"), []byte("shared value two")
+	since := Manifest{}
+	for i, step := range []func() error{
+		func() error {
+			for _, k := range []string{"a", "b"} {
+				if err := m.Put(k, one); err != nil {
+					return err
+				}
+			}
+			return m.Put("c", []byte("a value of its own"))
+		},
+		// a key given a stored value appends nothing
+		func() error {
+			if err := m.Put("d", one); err != nil {
+				return err
+			}
+			if err := m.Delete("a"); err != nil {
+				return err
+			}
+			for _, k := range []string{"e", "f"} {
+				if err := m.Put(k, two); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// the last references go and compaction moves what is left
+		func() error {
+			for _, k := range []string{"b", "d"} {
+				if err := m.Delete(k); err != nil {
+					return err
+				}
+			}
+			if err := m.Compact(context.Background()); err != nil {
+				return err
+			}
+			return m.Put("g", two)
+		},
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if since, err = m.IncrementalBackup(since, &b); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := RestoreBackups(restored, &b); err != nil {
+			t.Fatalf("restore backup %d: %v", i, err)
+		}
+		r, err := NewMutcask(PathConf(restored), CaskNumConf(4), DedupConf())
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, _ := m.ListKeys("", "", 0)
+		got, _ := r.ListKeys("", "", 0)
+		if fmt.Sprint(got) != fmt.Sprint(keys) {
+			t.Fatalf("backup %d restored keys %v, want %v", i, got, keys)
+		}
+		for _, k := range keys {
+			want, _ := m.Get(k)
+			if v, err := r.Get(k); err != nil || !bytes.Equal(v, want) {
+				t.Fatalf("backup %d restored %s %q: %v", i, k, v, err)
+			}
+		}
+		// the digest records match, reference counts included
+		records := func(db *leveldb.DB) map[string]string {
+			recs := make(map[string]string)
+			iter := db.NewIterator(sysRange(nsDigest), nil)
+			for iter.Next() {
+				recs[string(iter.Key())] = string(iter.Value())
+			}
+			iter.Release()
+			return recs
+		}
+		if got, want := records(r.keys), records(m.keys); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("backup %d restored digest records %x, want %x", i, got, want)
+		}
+		r.Close()
+	}
+}
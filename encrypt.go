@@ -0,0 +1,246 @@
+package mutcask
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// KeyProvider hands out the 32 byte keys records are sealed with
+type KeyProvider interface {
+	// CurrentKey returns the key new records are sealed with
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with id, records keep the key they were sealed
+	// with until they are written again
+	Key(id string) ([]byte, error)
+}
+
+type Cipher string
+
+const (
+	CipherAES256GCM         Cipher = "aes-256-gcm"
+	CipherXChaCha20Poly1305 Cipher = "xchacha20-poly1305"
+)
+
+// KeyInfo is recorded in the repo metadata for every key records were sealed
+// with, the list only grows so a rotated key stays resolvable
+type KeyInfo struct {
+	ID     string
+	Cipher Cipher
+}
+
+func newAEAD(c Cipher, key []byte) (cipher.AEAD, error) {
+	switch c {
+	case CipherAES256GCM:
+		if len(key) != 32 {
+			return nil, ErrKeyFile
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, ErrNoSupport
+}
+
+// keyring seals records with the current key and opens them with the one
+// they were sealed with. HintLV.EncKey is the index of that key within the
+// repo metadata plus one.
+/**
+		crc32	:	nonce	:	sealed codec and value	:	tag
+		4 		: 	12/24	:	xxxx					:	16
+**/
+type keyring struct {
+	sync.Mutex
+	dir      string
+	provider KeyProvider
+	infos    []KeyInfo
+	// key new records are sealed with, zero for read-only repos
+	cur   uint32
+	aeads map[uint32]cipher.AEAD
+}
+
+// openKeyring resolves the current key against the repo metadata, recording
+// it there when it is new. It returns nil when encryption is not configured,
+// which a repo holding sealed values refuses with ErrKeyRequired.
+func openKeyring(cfg *Config) (*keyring, error) {
+	meta, err := ReadRepoMeta(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.KeyProvider == nil {
+		if len(meta.Keys) > 0 {
+			return nil, ErrKeyRequired
+		}
+		return nil, nil
+	}
+	kr := &keyring{
+		dir:      cfg.Path,
+		provider: cfg.KeyProvider,
+		infos:    meta.Keys,
+		aeads:    make(map[uint32]cipher.AEAD),
+	}
+	if cfg.ReadOnly {
+		return kr, nil
+	}
+	id, key, err := cfg.KeyProvider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	for i, info := range meta.Keys {
+		if info.ID == id {
+			if info.Cipher != cfg.Cipher {
+				return nil, ErrKeyCipher
+			}
+			kr.cur = uint32(i + 1)
+		}
+	}
+	// an unusable cipher or key must not be recorded in the repo meta
+	aead, err := newAEAD(cfg.Cipher, key)
+	if err != nil {
+		return nil, err
+	}
+	if kr.cur == 0 {
+		meta.Keys = append(meta.Keys, KeyInfo{ID: id, Cipher: cfg.Cipher})
+		if err = writeRepoMeta(cfg.Path, meta); err != nil {
+			return nil, err
+		}
+		kr.infos = meta.Keys
+		kr.cur = uint32(len(meta.Keys))
+	}
+	kr.aeads[kr.cur] = aead
+	return kr, nil
+}
+
+func (kr *keyring) aead(idx uint32) (cipher.AEAD, error) {
+	kr.Lock()
+	defer kr.Unlock()
+	if aead, ok := kr.aeads[idx]; ok {
+		return aead, nil
+	}
+	if idx == 0 {
+		return nil, ErrKeyNotFound
+	}
+	if int(idx) > len(kr.infos) {
+		// a writer next to a read-only repo may have rotated since
+		meta, err := ReadRepoMeta(kr.dir)
+		if err != nil {
+			return nil, err
+		}
+		kr.infos = meta.Keys
+		if int(idx) > len(kr.infos) {
+			return nil, ErrKeyNotFound
+		}
+	}
+	info := kr.infos[idx-1]
+	key, err := kr.provider.Key(info.ID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(info.Cipher, key)
+	if err != nil {
+		return nil, err
+	}
+	kr.aeads[idx] = aead
+	return aead, nil
+}
+
+// seal encrypts plain with the current key under a random nonce, binding it
+// to key so records can not be swapped between keys
+func (kr *keyring) seal(key string, plain []byte) ([]byte, uint32, error) {
+	if kr.cur == 0 {
+		return nil, 0, ErrReadOnly
+	}
+	aead, err := kr.aead(kr.cur)
+	if err != nil {
+		return nil, 0, err
+	}
+	ns := aead.NonceSize()
+	out := make([]byte, ns, ns+len(plain)+aead.Overhead())
+	if _, err = rand.Read(out); err != nil {
+		return nil, 0, err
+	}
+	return aead.Seal(out, out[:ns], plain, []byte(key)), kr.cur, nil
+}
+
+// open authenticates and decrypts what follows the crc of a sealed record, a
+// failed tag check means the record rotted
+func (kr *keyring) open(key string, idx uint32, sealed []byte) ([]byte, error) {
+	aead, err := kr.aead(idx)
+	if err != nil {
+		return nil, err
+	}
+	ns := aead.NonceSize()
+	if len(sealed) < ns+aead.Overhead() {
+		return nil, ErrValueFormat
+	}
+	plain, err := aead.Open(nil, sealed[:ns], sealed[ns:], []byte(key))
+	if err != nil {
+		return nil, ErrDataRotted
+	}
+	return plain, nil
+}
+
+// KeyFile is a KeyProvider reading keys from a file of "<id> <hex key>" lines,
+// the last key is the current one. Lines starting with # are skipped.
+type KeyFile struct {
+	cur  string
+	keys map[string][]byte
+}
+
+var _ KeyProvider = (*KeyFile)(nil)
+
+func OpenKeyFile(path string) (*KeyFile, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	kf := &KeyFile{keys: make(map[string][]byte)}
+	sc := bufio.NewScanner(fh)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, ErrKeyFile
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) != 32 {
+			return nil, ErrKeyFile
+		}
+		kf.keys[fields[0]] = key
+		kf.cur = fields[0]
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	if kf.cur == "" {
+		return nil, ErrKeyFile
+	}
+	return kf, nil
+}
+
+func (kf *KeyFile) CurrentKey() (string, []byte, error) {
+	return kf.cur, kf.keys[kf.cur], nil
+}
+
+func (kf *KeyFile) Key(id string) ([]byte, error) {
+	key, ok := kf.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
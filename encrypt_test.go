@@ -0,0 +1,160 @@
+package mutcask
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeKeyFile(t *testing.T, path string, ids ...string) *KeyFile {
+	lines := []string{"# test keys"}
+	for i, id := range ids {
+		lines = append(lines, fmt.Sprintf("%s %s", id, strings.Repeat(fmt.Sprintf("%02x", i+1), 32)))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	kf, err := OpenKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kf
+}
+
+func TestEncryption(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	keyPath := filepath.Join(t.TempDir(), "keys")
+	values := map[string][]byte{
+		"compressed": []byte(strings.Repeat("very secret tenant data ", 20)),
+		"short":      []byte("secret"),
+	}
+
+	kf := writeKeyFile(t, keyPath, "k1")
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(1), EncryptionConf(kf, CipherAES256GCM), CompressionConf(CompressZstd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		if err := m.Put(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Close()
+	vlog, err := os.ReadFile(filepath.Join(dir, VLogName(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(vlog, []byte("secret")) {
+		t.Fatal("plain text in vlog")
+	}
+
+	// rotate to a new key and cipher, records sealed with k1 stay readable
+	kf = writeKeyFile(t, keyPath, "k1", "k2")
+	m, err = NewMutcask(PathConf(dir), CaskNumConf(1), EncryptionConf(kf, CipherXChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values["rotated"] = []byte("sealed with k2")
+	if err := m.Put("rotated", values["rotated"]); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		got, err := m.Get(k)
+		if err != nil || !bytes.Equal(got, v) {
+			t.Fatalf("%s: get %q (%v)", k, got, err)
+		}
+		if size, err := m.Size(k); err != nil || size != len(v) {
+			t.Fatalf("%s: size %d (%v)", k, size, err)
+		}
+		var buf bytes.Buffer
+		if _, err := m.Read(k, &buf); err != nil || !bytes.Equal(buf.Bytes(), v) {
+			t.Fatalf("%s: read %q (%v)", k, buf.Bytes(), err)
+		}
+		buf.Reset()
+		if _, err := m.ReadRange(k, &buf, 2, 3); err != nil || !bytes.Equal(buf.Bytes(), v[2:5]) {
+			t.Fatalf("%s: range read %q (%v)", k, buf.Bytes(), err)
+		}
+	}
+	recs, err := m.Records(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	meta, err := ReadRepoMeta(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KeyInfo{{"k1", CipherAES256GCM}, {"k2", CipherXChaCha20Poly1305}}
+	if meta.Version != RepoVersion || fmt.Sprint(meta.Keys) != fmt.Sprint(want) {
+		t.Fatalf("unexpected repo meta %+v", meta)
+	}
+
+	if _, err := NewMutcask(PathConf(dir), CaskNumConf(1), EncryptionConf(kf, CipherAES256GCM)); err != ErrKeyCipher {
+		t.Fatalf("expected ErrKeyCipher, got %v", err)
+	}
+	// a key that cannot be used is not recorded
+	kf3 := writeKeyFile(t, filepath.Join(t.TempDir(), "keys"), "k1", "k2", "k3")
+	if _, err := NewMutcask(PathConf(dir), CaskNumConf(1), EncryptionConf(kf3, Cipher("rot13"))); err == nil {
+		t.Fatal("opened with an unknown cipher")
+	}
+	if meta, err := ReadRepoMeta(dir); err != nil || fmt.Sprint(meta.Keys) != fmt.Sprint(want) {
+		t.Fatalf("repo meta after failed open %+v: %v", meta, err)
+	}
+
+	// a repo holding sealed values is not opened without its keys
+	if _, err := NewMutcask(PathConf(dir), CaskNumConf(1)); err != ErrKeyRequired {
+		t.Fatalf("expected ErrKeyRequired, got %v", err)
+	}
+	if _, err := NewMutcask(PathConf(dir), CaskNumConf(1), ReadOnlyConf()); err != ErrKeyRequired {
+		t.Fatalf("expected ErrKeyRequired read-only, got %v", err)
+	}
+
+	// the tag catches a flipped bit
+	var short RecordInfo
+	for _, rec := range recs {
+		if rec.Key == "short" {
+			short = rec
+		}
+	}
+	if short.EncKey != 1 || short.LSize != uint32(len(values["short"])) {
+		t.Fatalf("unexpected record %+v", short)
+	}
+	vlog, err = os.ReadFile(filepath.Join(dir, VLogName(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vlog[short.Offset+uint64(short.Size)-1] ^= 1
+	if err := os.WriteFile(filepath.Join(dir, VLogName(0)), vlog, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err = NewMutcask(PathConf(dir), CaskNumConf(1), EncryptionConf(kf, CipherXChaCha20Poly1305))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if _, err := m.Get("short"); err != ErrDataRotted {
+		t.Fatalf("expected ErrDataRotted, got %v", err)
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("k1 abcd\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenKeyFile(path); err != ErrKeyFile {
+		t.Fatalf("expected ErrKeyFile, got %v", err)
+	}
+	kf := writeKeyFile(t, path, "old", "new")
+	id, key, err := kf.CurrentKey()
+	if err != nil || id != "new" || len(key) != 32 {
+		t.Fatalf("unexpected current key %s (%v)", id, err)
+	}
+	if _, err := kf.Key("gone"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
@@ -14,4 +14,23 @@ var (
 	ErrReadHintBeyondRange = xerrors.New("mutcask: read hint out of file range")
 	ErrRepoLocked          = xerrors.New("mutcask: repo has been locked")
 	ErrNoSupport           = xerrors.New("mutcask: method not support")
+	ErrKeyEmpty            = xerrors.New("mutcask: key should not be empty")
+	ErrKeyReserved         = xerrors.New("mutcask: key uses reserved prefix")
+	ErrRepoMeta            = xerrors.New("mutcask: invalid repo metadata")
+	ErrRepoVersion         = xerrors.New("mutcask: repo version not support")
+	ErrCaskNumMismatch     = xerrors.New("mutcask: cask num does not match repo")
+	ErrBackupFormat        = xerrors.New("mutcask: invalid backup format")
+	ErrBackupChain         = xerrors.New("mutcask: backup does not continue the restored chain")
+	ErrReadOnly            = xerrors.New("mutcask: repo is opened read-only")
+	ErrRange               = xerrors.New("mutcask: range out of value")
+	ErrKeyNotFound         = xerrors.New("mutcask: encryption key not found")
+	ErrKeyFile             = xerrors.New("mutcask: invalid key file")
+	ErrKeyCipher           = xerrors.New("mutcask: key is recorded with another cipher")
+	ErrKeyRequired         = xerrors.New("mutcask: repo holds sealed values, a key provider is required")
+	ErrChunk               = xerrors.New("mutcask: no such chunk or wrong chunk size")
+	ErrMetaSize            = xerrors.New("mutcask: metadata is too large")
+	ErrConflict            = xerrors.New("mutcask: key does not hold the expected value")
+	ErrClosed              = xerrors.New("mutcask: repo is closed")
+	ErrCarRoots            = xerrors.New("mutcask: car needs at least one root")
+	ErrNoJournal           = xerrors.New("mutcask: deletes are not journaled since the backup")
 )
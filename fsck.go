@@ -0,0 +1,403 @@
+package mutcask
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	fslock "github.com/ipfs/go-fs-lock"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// kinds of problems fsck reports
+const (
+	FsckMetaMissing  = "meta-missing"
+	FsckMetaInvalid  = "meta-invalid"
+	FsckBadHint      = "bad-hint"
+	FsckMissingVLog  = "missing-vlog"
+	FsckOutOfRange   = "out-of-range"
+	FsckCrc          = "crc"
+	FsckOverlap      = "overlap"
+	FsckOrphanVLog   = "orphan-vlog"
+	FsckUnreferenced = "unreferenced"
+	FsckTail         = "tail"
+)
+
+type FsckOptions struct {
+	// cask num used when the repo has no metadata
+	CaskNum uint32
+	// delete index entries which point outside their vlog or fail crc, and
+	// the entries of chunked values missing a chunk. Shared values lose the
+	// references dropped.
+	DropBroken bool
+	// truncate unreferenced bytes at the end of vlogs
+	TruncateTails bool
+}
+
+type FsckIssue struct {
+	Kind     string
+	Key      string
+	Cask     uint32
+	Offset   uint64
+	Size     uint64
+	Detail   string
+	Repaired bool
+}
+
+// IsError tells whether the issue is a real inconsistency, unreferenced
+// ranges are left by deletes and overwrites and only waste space
+func (i *FsckIssue) IsError() bool {
+	return i.Kind != FsckUnreferenced && i.Kind != FsckTail && i.Kind != FsckMetaMissing
+}
+
+type FsckCask struct {
+	Size         uint64
+	Referenced   uint64
+	Unreferenced uint64
+}
+
+type FsckReport struct {
+	Path   string
+	Meta   *RepoMeta
+	Keys   uint64
+	Casks  map[uint32]*FsckCask
+	Issues []*FsckIssue
+}
+
+// Errors counts unrepaired issues which are real inconsistencies
+func (r *FsckReport) Errors() int {
+	n := 0
+	for _, is := range r.Issues {
+		if is.IsError() && !is.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *FsckReport) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	printf := func(format string, a ...interface{}) error {
+		wn, err := fmt.Fprintf(w, format, a...)
+		n += int64(wn)
+		return err
+	}
+	printf("repo: %s\n", r.Path)
+	if r.Meta != nil {
+		printf("meta: version %d, %d casks\n", r.Meta.Version, r.Meta.CaskNum)
+	}
+	printf("keys: %d\n", r.Keys)
+	ids := make([]uint32, 0, len(r.Casks))
+	for id := range r.Casks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		c := r.Casks[id]
+		printf("cask %08d: %d bytes, %d referenced, %d unreferenced\n", id, c.Size, c.Referenced, c.Unreferenced)
+	}
+	warns := 0
+	for _, is := range r.Issues {
+		level := "ERROR"
+		if !is.IsError() {
+			level = "WARN"
+			warns++
+		}
+		if is.Repaired {
+			level = "FIXED"
+		}
+		printf("%s %s", level, is.Kind)
+		if is.Key != "" {
+			printf(" key=%s", is.Key)
+		}
+		if is.Kind != FsckMetaMissing && is.Kind != FsckMetaInvalid {
+			printf(" cask=%d offset=%d size=%d", is.Cask, is.Offset, is.Size)
+		}
+		if is.Detail != "" {
+			printf(" %s", is.Detail)
+		}
+		printf("\n")
+	}
+	err := printf("%d errors, %d warnings\n", r.Errors(), warns)
+	return n, err
+}
+
+type fsckExtent struct {
+	key    string
+	offset uint64
+	size   uint64
+	broken bool
+}
+
+// Fsck checks the consistency of a repo which is not opened by any process.
+func Fsck(path string, opts FsckOptions) (*FsckReport, error) {
+	finfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !finfo.IsDir() {
+		return nil, ErrPath
+	}
+	locked, err := fslock.Locked(path, lockFileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not check lock status: %w", err)
+	}
+	if locked {
+		return nil, ErrRepoLocked
+	}
+	unlockRepo, err := fslock.Lock(path, lockFileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not lock the repo: %w", err)
+	}
+	defer unlockRepo.Close()
+
+	report := &FsckReport{
+		Path:  path,
+		Casks: make(map[uint32]*FsckCask),
+	}
+	caskNum := opts.CaskNum
+	if caskNum == 0 {
+		caskNum = defaultConfig().CaskNum
+	}
+	meta, err := ReadRepoMeta(path)
+	switch {
+	case err == nil:
+		report.Meta = meta
+		caskNum = meta.CaskNum
+		if meta.Version > RepoVersion || meta.CaskNum == 0 {
+			report.Issues = append(report.Issues, &FsckIssue{Kind: FsckMetaInvalid, Detail: fmt.Sprintf("version %d, %d casks", meta.Version, meta.CaskNum)})
+			return report, nil
+		}
+	case os.IsNotExist(err):
+		report.Issues = append(report.Issues, &FsckIssue{Kind: FsckMetaMissing, Detail: fmt.Sprintf("assuming %d casks", caskNum)})
+	default:
+		report.Issues = append(report.Issues, &FsckIssue{Kind: FsckMetaInvalid, Detail: err.Error()})
+		return report, nil
+	}
+
+	keysPath := filepath.Join(path, keys_dir)
+	if _, err := os.Stat(keysPath); err != nil {
+		return nil, err
+	}
+	db, err := leveldb.OpenFile(keysPath, &opt.Options{
+		ReadOnly: !opts.DropBroken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// orphan vlogs can not be reached by any key
+	dirents, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	vlogs := make(map[uint32]*os.File)
+	defer func() {
+		for _, fh := range vlogs {
+			fh.Close()
+		}
+	}()
+	for _, ent := range dirents {
+		if ent.IsDir() || !strings.HasSuffix(ent.Name(), vLogSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(ent.Name(), vLogSuffix), 10, 32)
+		if err != nil || uint32(id) >= caskNum {
+			report.Issues = append(report.Issues, &FsckIssue{Kind: FsckOrphanVLog, Detail: ent.Name()})
+			continue
+		}
+		fh, err := os.OpenFile(filepath.Join(path, ent.Name()), os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		vlogs[uint32(id)] = fh
+		size, err := fileSize(fh)
+		if err != nil {
+			return nil, err
+		}
+		report.Casks[uint32(id)] = &FsckCask{Size: size}
+	}
+
+	extents := make(map[uint32][]*fsckExtent)
+	var broken []*FsckIssue
+	// chunks are checked first, so their parents are found broken along
+	brokenKeys := make(map[string]bool)
+	fail := func(issue *FsckIssue) {
+		broken = append(broken, issue)
+		brokenKeys[issue.Key] = true
+	}
+	for _, rng := range valueRanges() {
+		iter := db.NewIterator(rng, nil)
+		for iter.Next() {
+			key := string(iter.Key())
+			if key[0] != sysPrefix {
+				report.Keys++
+			}
+			id := caskID(key, caskNum)
+			issue := &FsckIssue{Key: key, Cask: id}
+			hlv, err := HintLVFromBytes(iter.Value())
+			if err != nil {
+				issue.Kind = FsckBadHint
+				issue.Detail = err.Error()
+				fail(issue)
+				continue
+			}
+			if hlv.Digest != nil {
+				// shared values are checked where they are stored
+				rec, err := getDigest(db, hlv.Digest)
+				if err != nil {
+					issue.Kind = FsckBadHint
+					issue.Detail = "digest record: " + err.Error()
+					fail(issue)
+					continue
+				}
+				id, hlv = rec.Cask, rec.hintLV()
+				issue.Cask = id
+			}
+			if hlv.Inline {
+				// checked by leveldb along with the entry
+				continue
+			}
+			if hlv.Chunks != nil {
+				// the chunks are checked as entries of their own
+				for i := uint32(0); i < hlv.Chunks.Count; i++ {
+					ck := chunkKey(hlv.Chunks.ID, i, entryKey(key))
+					has, err := db.Has([]byte(ck), nil)
+					if err != nil {
+						iter.Release()
+						return nil, err
+					}
+					if !has || brokenKeys[ck] {
+						issue.Kind = FsckBadHint
+						issue.Detail = fmt.Sprintf("chunk %d missing", i)
+						if has {
+							issue.Detail = fmt.Sprintf("chunk %d broken", i)
+						}
+						fail(issue)
+						break
+					}
+				}
+				continue
+			}
+			issue.Offset, issue.Size = hlv.VOffset, uint64(hlv.VSize)
+			fh, ok := vlogs[id]
+			if !ok {
+				issue.Kind = FsckMissingVLog
+				fail(issue)
+				continue
+			}
+			ext := &fsckExtent{key: key, offset: hlv.VOffset, size: uint64(hlv.VSize)}
+			extents[id] = append(extents[id], ext)
+			if ext.offset+ext.size > report.Casks[id].Size {
+				issue.Kind = FsckOutOfRange
+				ext.broken = true
+				fail(issue)
+				continue
+			}
+			buf, err := readRecord(fh, &Hint{Key: key, VOffset: hlv.VOffset, VSize: hlv.VSize})
+			if err != nil {
+				iter.Release()
+				return nil, err
+			}
+			err = VerifyValue(*buf)
+			vBuf.Put(buf)
+			if err != nil {
+				issue.Kind = FsckCrc
+				issue.Detail = err.Error()
+				ext.broken = true
+				fail(issue)
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+	}
+	report.Issues = append(report.Issues, broken...)
+
+	if opts.DropBroken && len(broken) > 0 {
+		batch := new(leveldb.Batch)
+		// the references dropped entries hold on shared values go along
+		var shared []string
+		for _, is := range broken {
+			batch.Delete([]byte(is.Key))
+			if d, err := db.Get([]byte(is.Key), nil); err == nil {
+				if hlv, err := HintLVFromBytes(d); err == nil && hlv.Digest != nil {
+					shared = append(shared, is.Key)
+				}
+			}
+		}
+		if err := unref(db, batch, shared...); err != nil {
+			return nil, err
+		}
+		if err := db.Write(batch, nil); err != nil {
+			return nil, err
+		}
+		for _, is := range broken {
+			is.Repaired = true
+		}
+	}
+
+	ids := make([]uint32, 0, len(vlogs))
+	for id := range vlogs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		exts := extents[id]
+		sort.Slice(exts, func(i, j int) bool { return exts[i].offset < exts[j].offset })
+		cask := report.Casks[id]
+		end := uint64(0)
+		var prev *fsckExtent
+		for _, ext := range exts {
+			if ext.broken && opts.DropBroken {
+				continue
+			}
+			if ext.offset+ext.size > cask.Size {
+				// reported as out of range already
+				continue
+			}
+			if prev != nil && ext.offset == prev.offset && ext.size == prev.size {
+				// several keys sharing one extent
+				continue
+			}
+			if ext.offset < end {
+				report.Issues = append(report.Issues, &FsckIssue{
+					Kind:   FsckOverlap,
+					Key:    ext.key,
+					Cask:   id,
+					Offset: ext.offset,
+					Size:   ext.size,
+					Detail: "overlaps " + prev.key,
+				})
+			} else if ext.offset > end {
+				report.Issues = append(report.Issues, &FsckIssue{Kind: FsckUnreferenced, Cask: id, Offset: end, Size: ext.offset - end})
+				cask.Unreferenced += ext.offset - end
+			}
+			cask.Referenced += ext.size
+			if ext.offset+ext.size > end {
+				end = ext.offset + ext.size
+			}
+			prev = ext
+		}
+		if cask.Size > end {
+			issue := &FsckIssue{Kind: FsckTail, Cask: id, Offset: end, Size: cask.Size - end}
+			cask.Unreferenced += issue.Size
+			if opts.TruncateTails {
+				if err := vlogs[id].Truncate(int64(end)); err != nil {
+					return nil, err
+				}
+				issue.Repaired = true
+			}
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,180 @@
+package mutcask
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	var kvdata = []kvt{
+		{"Qmc35RPEYrW3Mj1mki6thkAjx6a1ZFkU3UYxAyFhMmngr2", []byte("124567")},
+		{"QmTwNzgUFg2kCZ47AmsKUDHwnfAhcGj6TB4mNZcott9zWc", []byte("224567")},
+		{"QmYgPV5bT37u56qePZUqLQ15JhnopaSmVx8ao39RUCoZEj", []byte("324567")},
+	}
+	dir := tmpdirpath(t)
+	mutc, err := NewMutcask(PathConf(dir), CaskNumConf(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range kvdata {
+		if err := mutc.Put(item.Key, item.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// leave a dead value in the middle and a tail at the end
+	if err := mutc.Put(kvdata[0].Key, []byte("overwritten")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mutc.Delete(kvdata[0].Key); err != nil {
+		t.Fatal(err)
+	}
+	hint, err := get_hint(mutc.keys, kvdata[1].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutc.Close()
+
+	report, err := Fsck(dir, FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Errors() != 0 || report.Keys != 2 {
+		t.Fatalf("unexpected report %#v", report)
+	}
+	cask := report.Casks[0]
+	if cask.Unreferenced != 10+15 || cask.Referenced+cask.Unreferenced != cask.Size {
+		t.Fatalf("unexpected cask summary %#v", cask)
+	}
+
+	fh, err := os.OpenFile(filepath.Join(dir, VLogName(0)), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fh.WriteAt([]byte{'x'}, int64(hint.VOffset+4)); err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+	if err := os.WriteFile(filepath.Join(dir, "stray.vlog"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = Fsck(dir, FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]int{}
+	for _, is := range report.Issues {
+		kinds[is.Kind]++
+	}
+	if report.Errors() != 2 || kinds[FsckCrc] != 1 || kinds[FsckOrphanVLog] != 1 {
+		t.Fatalf("unexpected issues %v", kinds)
+	}
+
+	report, err = Fsck(dir, FsckOptions{DropBroken: true, TruncateTails: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "stray.vlog"))
+	report, err = Fsck(dir, FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, is := range report.Issues {
+		if is.Kind == FsckTail || is.IsError() {
+			t.Fatalf("unexpected issue after repair %#v", is)
+		}
+	}
+	if report.Keys != 1 {
+		t.Fatalf("broken key should be dropped, %d keys left", report.Keys)
+	}
+}
+
+func TestFsckDropBrokenParents(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(1), DedupConf(), ChunkConf(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := []byte("shared by two keys")
+	for _, k := range []string{"a", "b"} {
+		if err := m.Put(k, shared); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var big []byte
+	for i := 0; len(big) < 250; i++ {
+		big = strconv.AppendInt(big, int64(i), 10)
+	}
+	if err := m.Put("big", big); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Put("fine", []byte("left alone")); err != nil {
+		t.Fatal(err)
+	}
+	var offsets []uint64
+	hint, err := get_hint(m.keys, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets = append(offsets, hint.VOffset)
+	if hint, err = get_hint(m.keys, "big"); err != nil {
+		t.Fatal(err)
+	}
+	if hint, err = get_hint(m.keys, chunkKey(hint.Chunks.ID, 1, "big")); err != nil {
+		t.Fatal(err)
+	}
+	offsets = append(offsets, hint.VOffset)
+	m.Close()
+
+	fh, err := os.OpenFile(filepath.Join(dir, VLogName(0)), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, off := range offsets {
+		if _, err := fh.WriteAt([]byte{'x'}, int64(off+4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fh.Close()
+
+	// the parent of the broken chunk is broken along with it
+	report, err := Fsck(dir, FsckOptions{DropBroken: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped := map[string]bool{}
+	for _, is := range report.Issues {
+		if is.Repaired {
+			dropped[entryKey(is.Key)] = true
+		}
+	}
+	if !dropped["a"] || !dropped["b"] || !dropped["big"] || dropped["fine"] {
+		t.Fatalf("dropped %v", dropped)
+	}
+	if report, err = Fsck(dir, FsckOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if report.Errors() != 0 || report.Keys != 1 {
+		t.Fatalf("unexpected report after repair %#v", report)
+	}
+
+	// the shared value lost its references
+	m, err = NewMutcask(PathConf(dir), CaskNumConf(1), DedupConf(), ChunkConf(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	iter := m.keys.NewIterator(sysRange(nsDigest), nil)
+	for iter.Next() {
+		if rec, _ := getDigest(m.keys, []byte(sysKeySuffix(iter.Key()))); rec != nil && rec.VOffset == offsets[0] {
+			t.Fatalf("digest record of dropped keys left %+v", rec)
+		}
+	}
+	iter.Release()
+	if v, err := m.Get("fine"); err != nil || string(v) != "left alone" {
+		t.Fatalf("get %q: %v", v, err)
+	}
+}
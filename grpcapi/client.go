@@ -0,0 +1,234 @@
+package grpcapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/filedag-project/mutcask"
+	"github.com/filedag-project/mutcask/grpcapi/pb"
+	"google.golang.org/grpc"
+)
+
+// BatchOp is one operation of a Batch, Value is ignored by deletes
+type BatchOp struct {
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
+// Client is a KVDB served by a remote Server
+type Client struct {
+	cc *grpc.ClientConn
+	kv pb.KVClient
+	// where listings which fail midway are reported
+	Logger *slog.Logger
+}
+
+var _ mutcask.KVDB = (*Client)(nil)
+
+// NewClient uses cc for its calls, Close closes it
+func NewClient(cc *grpc.ClientConn) *Client {
+	return &Client{cc: cc, kv: pb.NewKVClient(cc), Logger: slog.Default()}
+}
+
+func (c *Client) Put(key string, value []byte) error {
+	stream, err := c.kv.Put(context.Background())
+	if err != nil {
+		return fromStatus(err)
+	}
+	req := &pb.PutRequest{Key: key}
+	for {
+		n := len(value)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		req.Data, value = value[:n], value[n:]
+		if err := stream.Send(req); err != nil {
+			if err == io.EOF {
+				// the server failed the call, CloseAndRecv tells why
+				break
+			}
+			return fromStatus(err)
+		}
+		if len(value) == 0 {
+			break
+		}
+		req = &pb.PutRequest{}
+	}
+	_, err = stream.CloseAndRecv()
+	return fromStatus(err)
+}
+
+func (c *Client) Delete(key string) error {
+	_, err := c.kv.Delete(context.Background(), &pb.KeyRequest{Key: key})
+	return fromStatus(err)
+}
+
+func (c *Client) Get(key string) ([]byte, error) {
+	size, err := c.Size(key)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, size)
+	_, err = c.ReadRange(key, (*sliceWriter)(&buf), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (c *Client) Size(key string) (int, error) {
+	res, err := c.kv.Size(context.Background(), &pb.KeyRequest{Key: key})
+	if err != nil {
+		return -1, fromStatus(err)
+	}
+	return int(res.Size), nil
+}
+
+func (c *Client) CheckSum(key string) (string, error) {
+	res, err := c.kv.CheckSum(context.Background(), &pb.KeyRequest{Key: key})
+	if err != nil {
+		return "", fromStatus(err)
+	}
+	return res.Checksum, nil
+}
+
+func (c *Client) Read(key string, w io.Writer) (int, error) {
+	return c.ReadRange(key, w, 0, -1)
+}
+
+// ReadRange copies length bytes of the value starting at offset to w, a
+// negative length reads to the end
+func (c *Client) ReadRange(key string, w io.Writer, offset, length int64) (int, error) {
+	if length == 0 {
+		return 0, nil
+	}
+	if length < 0 {
+		// 0 asks the server for the rest of the value
+		length = 0
+	}
+	stream, err := c.kv.Read(context.Background(), &pb.ReadRequest{Key: key, Offset: offset, Length: length})
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+	n := 0
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, fromStatus(err)
+		}
+		m, err := w.Write(res.Data)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// AllKeysChan receives the first keys before it returns, so a listing the
+// server fails outright returns its error. A stream failing later can only
+// close the channel early, it is logged. Keys returns such errors.
+func (c *Client) AllKeysChan(ctx context.Context) (chan string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.kv.AllKeys(ctx, &pb.AllKeysRequest{})
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err)
+	}
+	res, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		cancel()
+		return nil, fromStatus(err)
+	}
+	kc := make(chan string)
+	go func() {
+		defer cancel()
+		defer close(kc)
+		for err == nil {
+			for _, key := range res.Keys {
+				select {
+				case kc <- key:
+				case <-ctx.Done():
+					return
+				}
+			}
+			res, err = stream.Recv()
+		}
+		if err != io.EOF && ctx.Err() == nil {
+			c.Logger.Error("listing keys", "err", fromStatus(err))
+		}
+	}()
+	return kc, nil
+}
+
+// Keys calls fn with the keys starting with prefix, it stops at the first
+// error fn or the stream returns
+func (c *Client) Keys(ctx context.Context, prefix string, fn func(key string) error) error {
+	stream, err := c.kv.AllKeys(ctx, &pb.AllKeysRequest{Prefix: prefix})
+	if err != nil {
+		return fromStatus(err)
+	}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fromStatus(err)
+		}
+		for _, key := range res.Keys {
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Batch applies ops in order in a single call, stopping at the first failing
+// one. Operations before it stay applied.
+func (c *Client) Batch(ops []BatchOp) error {
+	req := &pb.BatchRequest{Ops: make([]*pb.BatchOp, len(ops))}
+	for i, op := range ops {
+		req.Ops[i] = &pb.BatchOp{Key: op.Key, Value: op.Value, Delete: op.Delete}
+	}
+	_, err := c.kv.Batch(context.Background(), req)
+	return fromStatus(err)
+}
+
+func (c *Client) Stats() (*mutcask.Stats, error) {
+	res, err := c.kv.Stats(context.Background(), &pb.StatsRequest{})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	st := &mutcask.Stats{
+		Keys:         res.Keys,
+		VLogBytes:    res.VlogBytes,
+		LiveBytes:    res.LiveBytes,
+		LogicalBytes: res.LogicalBytes,
+	}
+	for _, cs := range res.Casks {
+		st.Casks = append(st.Casks, mutcask.CaskStats{
+			ID:           cs.Id,
+			Keys:         cs.Keys,
+			VLogBytes:    cs.VlogBytes,
+			LiveBytes:    cs.LiveBytes,
+			LogicalBytes: cs.LogicalBytes,
+		})
+	}
+	return st, nil
+}
+
+func (c *Client) Close() error {
+	return c.cc.Close()
+}
+
+type sliceWriter []byte
+
+func (s *sliceWriter) Write(p []byte) (int, error) {
+	*s = append(*s, p...)
+	return len(p), nil
+}
@@ -0,0 +1,170 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/filedag-project/mutcask"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newClient(t *testing.T, db mutcask.KVDB) *Client {
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	NewServer(db).Register(gs)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+	cc, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(cc)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient(t *testing.T) {
+	db, err := mutcask.NewMutcask(mutcask.PathConf(t.TempDir()), mutcask.CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := newClient(t, db)
+
+	// larger than a stream chunk
+	big := make([]byte, 3*chunkSize+100)
+	rand.New(rand.NewSource(1)).Read(big)
+	if err := c.Put("big", big); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Get("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, big) {
+		t.Fatal("value read back does not match")
+	}
+	sum, err := c.CheckSum("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, _ := db.CheckSum("big")
+	if sum != local {
+		t.Fatalf("checksum %s, expected %s", sum, local)
+	}
+	var buf bytes.Buffer
+	if _, err := mutcask.ReadRange(c, "big", &buf, chunkSize-10, 20); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), big[chunkSize-10:chunkSize+10]) {
+		t.Fatal("range does not match")
+	}
+
+	if err := c.Put("empty", nil); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := c.Size("empty"); err != nil || size != 0 {
+		t.Fatalf("size of empty value: %d %v", size, err)
+	}
+
+	// errors come back as the values the local store returns
+	if _, err := c.Get("missing"); err != mutcask.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := c.Put(strings.Repeat("k", mutcask.MaxKeySize+1), []byte("v")); err != mutcask.ErrKeySizeTooLong {
+		t.Fatalf("expected ErrKeySizeTooLong, got %v", err)
+	}
+
+	err = c.Batch([]BatchOp{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2")},
+		{Key: "empty", Delete: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kc, err := c.AllKeysChan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for key := range kc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "a,b,big" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	keys = keys[:0]
+	err = c.Keys(context.Background(), "b", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "b,big" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	if err := c.Delete("big"); err != nil {
+		t.Fatal(err)
+	}
+	st, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Keys != 2 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestClientMemkv(t *testing.T) {
+	c := newClient(t, mutcask.NewMemkv())
+	if err := c.Put("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := mutcask.ReadRange(c, "key", &buf, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "alu" {
+		t.Fatalf("unexpected range %q", buf.String())
+	}
+	if _, err := c.Stats(); err != mutcask.ErrNoSupport {
+		t.Fatalf("expected ErrNoSupport, got %v", err)
+	}
+}
+
+// failingKeys fails listing its keys
+type failingKeys struct {
+	mutcask.KVDB
+}
+
+func (failingKeys) AllKeysChan(context.Context) (chan string, error) {
+	return nil, mutcask.ErrNoSupport
+}
+
+func TestListingFails(t *testing.T) {
+	c := newClient(t, failingKeys{mutcask.NewMemkv()})
+	if _, err := c.AllKeysChan(context.Background()); err != mutcask.ErrNoSupport {
+		t.Fatalf("expected ErrNoSupport, got %v", err)
+	}
+	err := c.Keys(context.Background(), "", func(string) error { return nil })
+	if err != mutcask.ErrNoSupport {
+		t.Fatalf("expected ErrNoSupport, got %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+// Package grpcapi serves a mutcask KVDB over grpc and reaches one with a
+// Client which is a KVDB itself, so a local store can be swapped for a
+// remote one.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/filedag-project/mutcask"
+	"github.com/filedag-project/mutcask/grpcapi/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// values are streamed in pieces of this size
+const chunkSize = 1 << 20
+
+// keys sent per AllKeys message
+const keysPerMessage = 1024
+
+// largest value a Put may send unless configured otherwise
+const DefaultMaxValueSize = 1 << 30
+
+type statser interface {
+	Stats() (*mutcask.Stats, error)
+}
+
+type Server struct {
+	pb.UnimplementedKVServer
+	db mutcask.KVDB
+	// Put streams larger than this are refused
+	MaxValueSize int64
+}
+
+var _ pb.KVServer = (*Server)(nil)
+
+func NewServer(db mutcask.KVDB) *Server {
+	return &Server{db: db, MaxValueSize: DefaultMaxValueSize}
+}
+
+// Register adds the KV service to a grpc server
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	pb.RegisterKVServer(r, s)
+}
+
+func (s *Server) Put(stream pb.KV_PutServer) error {
+	req, err := stream.Recv()
+	if err == io.EOF {
+		req = &pb.PutRequest{}
+	} else if err != nil {
+		return err
+	}
+	body := &putReader{stream: stream, buf: req.Data, max: s.MaxValueSize}
+	err = mutcask.PutReader(s.db, req.Key, body)
+	if body.err != nil {
+		return body.err
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+	return stream.SendAndClose(&pb.PutResponse{})
+}
+
+// putReader yields the value a Put stream carries
+type putReader struct {
+	stream pb.KV_PutServer
+	buf    []byte
+	n      int64
+	max    int64
+	// what failed the stream, as opposed to storing the value
+	err error
+}
+
+func (r *putReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		req, err := r.stream.Recv()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.buf = req.Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.n += int64(n)
+	if r.n > r.max {
+		r.err = status.Error(codes.ResourceExhausted, "value too large")
+		return n, r.err
+	}
+	return n, nil
+}
+
+func (s *Server) Delete(ctx context.Context, req *pb.KeyRequest) (*pb.DeleteResponse, error) {
+	if err := s.db.Delete(req.Key); err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+func (s *Server) Size(ctx context.Context, req *pb.KeyRequest) (*pb.SizeResponse, error) {
+	size, err := s.db.Size(req.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.SizeResponse{Size: int64(size)}, nil
+}
+
+func (s *Server) CheckSum(ctx context.Context, req *pb.KeyRequest) (*pb.CheckSumResponse, error) {
+	sum, err := s.db.CheckSum(req.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.CheckSumResponse{Checksum: sum}, nil
+}
+
+func (s *Server) Read(req *pb.ReadRequest, stream pb.KV_ReadServer) error {
+	length := req.Length
+	if length == 0 {
+		length = -1
+	}
+	w := &chunkWriter{send: func(p []byte) error {
+		return stream.Send(&pb.ReadResponse{Data: p})
+	}}
+	if _, err := mutcask.ReadRange(s.db, req.Key, w, req.Offset, length); err != nil {
+		return toStatus(err)
+	}
+	return w.Flush()
+}
+
+func (s *Server) AllKeys(req *pb.AllKeysRequest, stream pb.KV_AllKeysServer) error {
+	kc, err := s.db.AllKeysChan(stream.Context())
+	if err != nil {
+		return toStatus(err)
+	}
+	keys := make([]string, 0, keysPerMessage)
+	for key := range kc {
+		if !strings.HasPrefix(key, req.Prefix) {
+			continue
+		}
+		keys = append(keys, key)
+		if len(keys) == keysPerMessage {
+			if err := stream.Send(&pb.AllKeysResponse{Keys: keys}); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := stream.Context().Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	if len(keys) > 0 {
+		return stream.Send(&pb.AllKeysResponse{Keys: keys})
+	}
+	return nil
+}
+
+func (s *Server) Batch(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	for _, op := range req.Ops {
+		var err error
+		if op.Delete {
+			err = s.db.Delete(op.Key)
+		} else {
+			err = s.db.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			return nil, toStatus(err)
+		}
+	}
+	return &pb.BatchResponse{}, nil
+}
+
+func (s *Server) Stats(ctx context.Context, req *pb.StatsRequest) (*pb.StatsResponse, error) {
+	st, ok := s.db.(statser)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, mutcask.ErrNoSupport.Error())
+	}
+	stats, err := st.Stats()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	res := &pb.StatsResponse{
+		Keys:         stats.Keys,
+		VlogBytes:    stats.VLogBytes,
+		LiveBytes:    stats.LiveBytes,
+		LogicalBytes: stats.LogicalBytes,
+	}
+	for _, cs := range stats.Casks {
+		res.Casks = append(res.Casks, &pb.CaskStats{
+			Id:           cs.ID,
+			Keys:         cs.Keys,
+			VlogBytes:    cs.VLogBytes,
+			LiveBytes:    cs.LiveBytes,
+			LogicalBytes: cs.LogicalBytes,
+		})
+	}
+	return res, nil
+}
+
+// chunkWriter collects writes into messages of chunkSize
+type chunkWriter struct {
+	buf  []byte
+	send func([]byte) error
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if cw.buf == nil {
+			cw.buf = make([]byte, 0, chunkSize)
+		}
+		m := copy(cw.buf[len(cw.buf):cap(cw.buf)], p)
+		cw.buf, p = cw.buf[:len(cw.buf)+m], p[m:]
+		if len(cw.buf) == chunkSize {
+			if err := cw.Flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (cw *chunkWriter) Flush() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	err := cw.send(cw.buf)
+	// the message may still be referenced by the transport
+	cw.buf = nil
+	return err
+}
+
+// errors which travel by their message, the client turns them back into the
+// same values
+var knownErrors = []error{
+	mutcask.ErrNotFound,
+	mutcask.ErrKeyEmpty,
+	mutcask.ErrKeySizeTooLong,
+	mutcask.ErrKeyReserved,
+	mutcask.ErrReadOnly,
+	mutcask.ErrRange,
+	mutcask.ErrDataRotted,
+	mutcask.ErrNoSupport,
+}
+
+func toStatus(err error) error {
+	code := codes.Unknown
+	switch {
+	case errors.Is(err, mutcask.ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, mutcask.ErrKeyEmpty), errors.Is(err, mutcask.ErrKeySizeTooLong), errors.Is(err, mutcask.ErrKeyReserved):
+		code = codes.InvalidArgument
+	case errors.Is(err, mutcask.ErrRange):
+		code = codes.OutOfRange
+	case errors.Is(err, mutcask.ErrReadOnly):
+		code = codes.FailedPrecondition
+	case errors.Is(err, mutcask.ErrDataRotted):
+		code = codes.DataLoss
+	case errors.Is(err, mutcask.ErrNoSupport):
+		code = codes.Unimplemented
+	}
+	return status.Error(code, err.Error())
+}
+
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, known := range knownErrors {
+		if s.Message() == known.Error() {
+			return known
+		}
+	}
+	return err
+}
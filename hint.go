@@ -3,11 +3,13 @@ package mutcask
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/btree"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -35,6 +37,8 @@ func (cm *CaskMap) Get(id uint32) (c *Cask, b bool) {
 }
 
 func (cm *CaskMap) CloseAll() {
+	cm.RLock()
+	defer cm.RUnlock()
 	for _, cask := range cm.m {
 		if cask != nil {
 			cask.Close()
@@ -133,7 +137,8 @@ func doMigrate(cfg *Config, keys *leveldb.DB) error {
 				return err
 			}
 
-			err = migrateKeys(hintLog, cfg.HintBootReadNum, keys)
+			err = migrateKeys(hintLog, cfg.HintBootReadNum, keys, cfg.Logger.With("file", ent.Name()))
+			hintLog.Close()
 			if err != nil {
 				return err
 			}
@@ -143,7 +148,10 @@ func doMigrate(cfg *Config, keys *leveldb.DB) error {
 	return nil
 }
 
-func migrateKeys(hint *os.File, hintBootReadNum int, keys *leveldb.DB) error {
+// keys migrated between two progress lines
+const migrateLogInterval = 100000
+
+func migrateKeys(hint *os.File, hintBootReadNum int, keys *leveldb.DB, log *slog.Logger) error {
 	finfo, err := hint.Stat()
 	if err != nil {
 		return err
@@ -159,6 +167,9 @@ func migrateKeys(hint *os.File, hintBootReadNum int, keys *leveldb.DB) error {
 	buf := vBuf.Get().(*vbuffer)
 	buf.size(HintEncodeSize * hintBootReadNum)
 	defer vBuf.Put(buf)
+	total := finfo.Size() / HintEncodeSize
+	migrated, failed := 0, 0
+	log.Info("migrating hint log", "keys", total)
 	for {
 		n, err := hint.Read(*buf)
 		if err != nil && err != io.EOF {
@@ -190,14 +201,18 @@ func migrateKeys(hint *os.File, hintBootReadNum int, keys *leveldb.DB) error {
 			if err != nil {
 				return err
 			}
-			if keys.Put([]byte(h.Key), hlvd, nil) == nil {
-				fmt.Printf("%s migated\n", h.Key)
+			if err := keys.Put([]byte(h.Key), hlvd, nil); err != nil {
+				failed++
+				log.Warn("migrate key", "key", h.Key, "offset", h.VOffset, "err", err)
 			} else {
-				fmt.Printf("%s migated failed\n", h.Key)
+				migrated++
+			}
+			if (migrated+failed)%migrateLogInterval == 0 {
+				log.Info("migrating hint log", "done", migrated+failed, "keys", total)
 			}
 		}
 	}
-
+	log.Info("migrated hint log", "migrated", migrated, "failed", failed)
 	return nil
 }
 
@@ -223,7 +238,12 @@ func buildCaskMap(cfg *Config, keys *leveldb.DB) (*CaskMap, error) {
 				return nil, err
 			}
 			cask := NewCask(uint32(id), keys)
+			cask.log = cfg.Logger.With("cask", id)
 			cm.Add(uint32(id), cask)
+			cask.gen, err = loadCaskGen(keys, uint32(id))
+			if err != nil {
+				return nil, err
+			}
 			// cask.hintLog, err = os.OpenFile(filepath.Join(cfg.Path, ent.Name()), os.O_RDWR, 0644)
 			// if err != nil {
 			// 	return nil, err
@@ -242,10 +262,11 @@ func buildCaskMap(cfg *Config, keys *leveldb.DB) (*CaskMap, error) {
 			if err != nil {
 				return nil, err
 			}
-			cask.vLogSize, err = fileSize(cask.vLog)
+			size, err := fileSize(cask.vLog)
 			if err != nil {
 				return nil, err
 			}
+			atomic.StoreUint64(&cask.vLogSize, size)
 		}
 	}
 
@@ -0,0 +1,369 @@
+// Package httpapi serves a mutcask KVDB over HTTP.
+//
+//	GET    /v1/keys/{key}                    value, Range requests supported
+//	HEAD   /v1/keys/{key}                    size and checksum only
+//	PUT    /v1/keys/{key}                    store the request body
+//	DELETE /v1/keys/{key}
+//	GET    /v1/keys?prefix=&cursor=&limit=   list keys in key order
+//	GET    /v1/stats                         repo statistics
+//
+// Values carry their sha256 in the X-Checksum-Sha256 header, a PUT sending
+// the header is rejected when the body does not match it. A GET of a value
+// whose checksum was not recorded gets it in a trailer instead, computed
+// while the value is sent; ranged GETs go without.
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/filedag-project/mutcask"
+)
+
+const ChecksumHeader = "X-Checksum-Sha256"
+
+// keys listed per page unless the request asks for fewer
+const DefaultListLimit = 1000
+
+// largest value a PUT may send unless configured otherwise
+const DefaultMaxValueSize = 1 << 30
+
+type lister interface {
+	ListKeys(prefix, start string, limit int) ([]string, error)
+}
+
+type keyStatter interface {
+	Stat(key string) (*mutcask.KeyStat, error)
+}
+
+type statser interface {
+	Stats() (*mutcask.Stats, error)
+}
+
+type Handler struct {
+	db  mutcask.KVDB
+	mux *http.ServeMux
+	// PUT bodies larger than this are refused
+	MaxValueSize int64
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+func NewHandler(db mutcask.KVDB) *Handler {
+	h := &Handler{
+		db:           db,
+		mux:          http.NewServeMux(),
+		MaxValueSize: DefaultMaxValueSize,
+	}
+	h.mux.HandleFunc("GET /v1/keys/{key...}", h.get)
+	h.mux.HandleFunc("PUT /v1/keys/{key...}", h.put)
+	h.mux.HandleFunc("DELETE /v1/keys/{key...}", h.delete)
+	h.mux.HandleFunc("GET /v1/keys", h.list)
+	h.mux.HandleFunc("GET /v1/stats", h.stats)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// ListResult is the body of a listing, NextCursor is set when there are
+// more keys to list
+type ListResult struct {
+	Keys       []string `json:"keys"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	size, sum, err := h.stat(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// only HEAD reads the value for a checksum which was not recorded
+	if sum == "" && r.Method == http.MethodHead {
+		if sum, err = h.db.CheckSum(key); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	hd := w.Header()
+	if sum != "" {
+		hd.Set(ChecksumHeader, sum)
+		hd.Set("ETag", `"`+sum+`"`)
+	}
+	hd.Set("Accept-Ranges", "bytes")
+	hd.Set("Content-Type", "application/octet-stream")
+
+	offset, length, ranged, err := ParseRange(r.Header.Get("Range"), int64(size))
+	if err != nil {
+		hd.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	status := http.StatusOK
+	if ranged {
+		hd.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+		status = http.StatusPartialContent
+	}
+	if r.Method == http.MethodHead {
+		hd.Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(status)
+		return
+	}
+	if ranged {
+		hd.Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(status)
+		if _, err = mutcask.ReadRange(h.db, key, w, offset, length); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+	if sum != "" {
+		hd.Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(status)
+		if _, err = h.db.Read(key, w); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+	// the checksum is computed while the value is sent and follows it in a
+	// trailer, which needs a chunked body
+	hd.Set("Trailer", ChecksumHeader)
+	w.WriteHeader(status)
+	hasher := sha256.New()
+	if _, err = h.db.Read(key, io.MultiWriter(w, hasher)); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	hd.Set(ChecksumHeader, hex.EncodeToString(hasher.Sum(nil)))
+}
+
+// stat returns the size of the value of key and its checksum when one was
+// recorded
+func (h *Handler) stat(key string) (int, string, error) {
+	if s, ok := h.db.(keyStatter); ok {
+		st, err := s.Stat(key)
+		if err != nil {
+			return 0, "", err
+		}
+		return st.Size, st.Checksum, nil
+	}
+	size, err := h.db.Size(key)
+	return size, "", err
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if r.ContentLength > h.MaxValueSize {
+		http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	body := &bodyReader{
+		h:    sha256.New(),
+		max:  h.MaxValueSize,
+		want: r.Header.Get(ChecksumHeader),
+	}
+	body.r = io.TeeReader(io.LimitReader(r.Body, h.MaxValueSize+1), body.h)
+	err := mutcask.PutReader(h.db, key, body)
+	switch {
+	case body.err == errTooLarge:
+		http.Error(w, body.err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case body.err != nil:
+		http.Error(w, body.err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		writeError(w, err)
+		return
+	}
+	sum := body.sum()
+	w.Header().Set(ChecksumHeader, sum)
+	w.Header().Set("ETag", `"`+sum+`"`)
+	w.WriteHeader(http.StatusCreated)
+}
+
+var (
+	errTooLarge = errors.New("value too large")
+	errChecksum = errors.New("checksum mismatch")
+)
+
+// bodyReader passes on a PUT body and fails the read reaching its end when
+// it does not match the checksum sent along, so the value is not stored
+type bodyReader struct {
+	// the body teed into h, one byte past max
+	r    io.Reader
+	h    hash.Hash
+	n    int64
+	max  int64
+	want string
+	// what went wrong with the body, as opposed to storing it
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	switch {
+	case b.n > b.max:
+		b.err = errTooLarge
+	case err == io.EOF && b.want != "" && !strings.EqualFold(b.want, b.sum()):
+		b.err = errChecksum
+	case err != nil && err != io.EOF:
+		b.err = err
+	}
+	if b.err != nil {
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *bodyReader) sum() string {
+	return hex.EncodeToString(b.h.Sum(nil))
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.db.Delete(r.PathValue("key")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, cursor := q.Get("prefix"), q.Get("cursor")
+	limit := DefaultListLimit
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	// one more than asked for tells whether there is a next page
+	keys, err := h.listKeys(r.Context(), prefix, cursor, limit+1)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := ListResult{Keys: keys}
+	if len(keys) > limit {
+		res.Keys = keys[:limit]
+		res.NextCursor = keys[limit-1]
+	}
+	if res.Keys == nil {
+		res.Keys = []string{}
+	}
+	writeJSON(w, res)
+}
+
+func (h *Handler) listKeys(ctx context.Context, prefix, cursor string, limit int) ([]string, error) {
+	if l, ok := h.db.(lister); ok {
+		return l.ListKeys(prefix, cursor, limit)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	kc, err := h.db.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range kc {
+		if strings.HasPrefix(key, prefix) && key > cursor {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, ctx.Err()
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.db.(statser)
+	if !ok {
+		http.Error(w, "stats not supported", http.StatusNotImplemented)
+		return
+	}
+	st, err := s.Stats()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, st)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, mutcask.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, mutcask.ErrKeyEmpty), errors.Is(err, mutcask.ErrKeySizeTooLong), errors.Is(err, mutcask.ErrKeyReserved):
+		status = http.StatusBadRequest
+	case errors.Is(err, mutcask.ErrReadOnly):
+		status = http.StatusForbidden
+	}
+	http.Error(w, err.Error(), status)
+}
+
+var errRange = errors.New("invalid range")
+
+// ParseRange reads a single range of a Range header, multiple ranges are
+// answered with the whole value
+func ParseRange(header string, size int64) (offset, length int64, ranged bool, err error) {
+	if header == "" || !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, size, false, nil
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	i := strings.IndexByte(spec, '-')
+	if i < 0 {
+		return 0, 0, false, errRange
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		// suffix range, the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false, errRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, errRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, errRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
@@ -0,0 +1,182 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/filedag-project/mutcask"
+)
+
+func newServer(t *testing.T, db mutcask.KVDB) *httptest.Server {
+	srv := httptest.NewServer(NewHandler(db))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func do(t *testing.T, method, url string, body io.Reader, header map[string]string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, b
+}
+
+func TestHandler(t *testing.T) {
+	db, err := mutcask.NewMutcask(mutcask.PathConf(t.TempDir()), mutcask.CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	testHandler(t, newServer(t, db))
+}
+
+func TestHandlerMemkv(t *testing.T) {
+	testHandler(t, newServer(t, mutcask.NewMemkv()))
+}
+
+func testHandler(t *testing.T, srv *httptest.Server) {
+	value := "0123456789abcdef"
+	sum := sha256.Sum256([]byte(value))
+	checksum := hex.EncodeToString(sum[:])
+
+	resp, _ := do(t, http.MethodPut, srv.URL+"/v1/keys/dir/key", strings.NewReader(value), nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("put: %s", resp.Status)
+	}
+	resp, _ = do(t, http.MethodPut, srv.URL+"/v1/keys/bad", strings.NewReader(value), map[string]string{ChecksumHeader: strings.Repeat("0", 64)})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("put with wrong checksum: %s", resp.Status)
+	}
+	resp, _ = do(t, http.MethodGet, srv.URL+"/v1/keys/bad", nil, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("value with wrong checksum stored: %s", resp.Status)
+	}
+
+	resp, body := do(t, http.MethodGet, srv.URL+"/v1/keys/dir/key", nil, nil)
+	if resp.StatusCode != http.StatusOK || string(body) != value {
+		t.Fatalf("get: %s %q", resp.Status, body)
+	}
+	// recorded checksums come in the header, others in a trailer
+	if got := resp.Header.Get(ChecksumHeader) + resp.Trailer.Get(ChecksumHeader); got != checksum {
+		t.Fatalf("unexpected checksum %s", got)
+	}
+
+	resp, body = do(t, http.MethodHead, srv.URL+"/v1/keys/dir/key", nil, nil)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 || resp.ContentLength != int64(len(value)) {
+		t.Fatalf("head: %s %d", resp.Status, resp.ContentLength)
+	}
+	if resp.Header.Get(ChecksumHeader) != checksum {
+		t.Fatalf("unexpected checksum %s", resp.Header.Get(ChecksumHeader))
+	}
+
+	for rng, want := range map[string]string{
+		"bytes=2-5":   "2345",
+		"bytes=10-":   "abcdef",
+		"bytes=-3":    "def",
+		"bytes=14-99": "ef",
+	} {
+		resp, body = do(t, http.MethodGet, srv.URL+"/v1/keys/dir/key", nil, map[string]string{"Range": rng})
+		if resp.StatusCode != http.StatusPartialContent || string(body) != want {
+			t.Fatalf("range %s: %s %q", rng, resp.Status, body)
+		}
+	}
+	resp, _ = do(t, http.MethodGet, srv.URL+"/v1/keys/dir/key", nil, map[string]string{"Range": "bytes=16-"})
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("unsatisfiable range: %s", resp.Status)
+	}
+
+	for i := 0; i < 5; i++ {
+		resp, _ = do(t, http.MethodPut, fmt.Sprintf("%s/v1/keys/list/%d", srv.URL, i), strings.NewReader("v"), nil)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("put: %s", resp.Status)
+		}
+	}
+	var listed []string
+	cursor := ""
+	for {
+		resp, body = do(t, http.MethodGet, srv.URL+"/v1/keys?prefix=list/&limit=2&cursor="+cursor, nil, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("list: %s", resp.Status)
+		}
+		res := ListResult{}
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatal(err)
+		}
+		listed = append(listed, res.Keys...)
+		if res.NextCursor == "" {
+			break
+		}
+		cursor = res.NextCursor
+	}
+	if strings.Join(listed, ",") != "list/0,list/1,list/2,list/3,list/4" {
+		t.Fatalf("unexpected listing %v", listed)
+	}
+
+	resp, _ = do(t, http.MethodDelete, srv.URL+"/v1/keys/dir/key", nil, nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: %s", resp.Status)
+	}
+	resp, _ = do(t, http.MethodGet, srv.URL+"/v1/keys/dir/key", nil, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("get deleted: %s", resp.Status)
+	}
+}
+
+func TestPutTooLarge(t *testing.T) {
+	db := mutcask.NewMemkv()
+	h := NewHandler(db)
+	h.MaxValueSize = 8
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	// no Content-Length, the limit is found while streaming
+	body := io.MultiReader(strings.NewReader("0123"), strings.NewReader("456789"))
+	resp, _ := do(t, http.MethodPut, srv.URL+"/v1/keys/big", body, nil)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("put too large: %s", resp.Status)
+	}
+	if _, err := db.Get("big"); err != mutcask.ErrNotFound {
+		t.Fatalf("value too large stored: %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	db, err := mutcask.NewMutcask(mutcask.PathConf(t.TempDir()), mutcask.CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(t, db)
+	resp, body := do(t, http.MethodGet, srv.URL+"/v1/stats", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stats: %s", resp.Status)
+	}
+	st := mutcask.Stats{}
+	if err := json.Unmarshal(body, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Keys != 1 {
+		t.Fatalf("expected 1 key, got %d", st.Keys)
+	}
+}
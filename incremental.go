@@ -0,0 +1,649 @@
+package mutcask
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	fslock "github.com/ipfs/go-fs-lock"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/**
+		magic	:	header	:	segments	:	deletes	:	puts	:	trailer
+		8		:	frame	:	frames		:	frames	:	frames	:	frame
+
+	a segment frame is followed by the raw vlog bytes it announces
+**/
+var incrementalMagic = []byte("MCINCRBK")
+
+const (
+	frameHeader      = 'H'
+	frameSegment     = 'V'
+	frameIndexPut    = 'I'
+	frameIndexDelete = 'D'
+)
+
+// CaskMark is how far a backup covered the vlog of one cask
+type CaskMark struct {
+	Gen  uint64
+	Size uint64
+}
+
+// Manifest describes the repo state a backup covers. The zero Manifest
+// stands for an empty repo, a backup since it is a full one.
+type Manifest struct {
+	Created int64
+	CaskNum uint32
+	Casks   map[uint32]CaskMark
+}
+
+type incrementalHeader struct {
+	Since    Manifest
+	Manifest Manifest
+	// ids of the keys sealed records refer to, see RepoMeta
+	Keys []KeyInfo `cbor:",omitempty"`
+}
+
+type segmentHeader struct {
+	Cask   uint32
+	Gen    uint64
+	Offset uint64
+	Size   uint64
+	// the vlog was rewritten since the previous backup, restore starts over
+	Reset bool
+}
+
+type indexEntry struct {
+	Key   []byte `cbor:"k"`
+	Value []byte `cbor:"v,omitempty"`
+}
+
+type incrementalTrailer struct {
+	Segments uint64
+	Bytes    uint64
+	Puts     uint64
+	Deletes  uint64
+}
+
+// IncrementalBackup writes what changed since a previous backup to w: the
+// vlog bytes appended past each cask's mark, index entries pointing there,
+// the keys deleted and the versions written or pruned meanwhile. Casks
+// compacted since then are sent whole, entries of chunked values with every
+// backup. Digest records go along with the entries pointing at them, restore
+// recounts their references.
+// The returned manifest is the since of the next backup of the chain.
+// Deletes are journaled from the first incremental backup a writer takes on,
+// chains continued where they were not fail with ErrNoJournal.
+func (m *mutcask) IncrementalBackup(since Manifest, w io.Writer) (Manifest, error) {
+	if since.CaskNum != 0 && since.CaskNum != m.cfg.CaskNum {
+		return Manifest{}, ErrCaskNumMismatch
+	}
+	m.maint.Lock()
+	defer m.maint.Unlock()
+	journaled, err := m.journaled()
+	if err != nil {
+		return Manifest{}, err
+	}
+	if since.CaskNum != 0 && !journaled {
+		return Manifest{}, ErrNoJournal
+	}
+	if !m.cfg.ReadOnly {
+		if err = m.startJournal(); err != nil {
+			return Manifest{}, err
+		}
+	}
+
+	// marks are taken before the index snapshot, entries written in between
+	// point past them and are left to the next backup
+	cur := Manifest{
+		Created: time.Now().Unix(),
+		CaskNum: m.cfg.CaskNum,
+		Casks:   make(map[uint32]CaskMark),
+	}
+	ids := m.caskIDs()
+	for _, id := range ids {
+		cask, _ := m.caskMap.Get(id)
+		cur.Casks[id] = CaskMark{Gen: cask.gen, Size: atomic.LoadUint64(&cask.vLogSize)}
+	}
+	db, vlogs, release := m.indexVLogs()
+	defer release()
+	snap, err := db.GetSnapshot()
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer snap.Release()
+	bw := bufio.NewWriter(w)
+	if _, err = bw.Write(incrementalMagic); err != nil {
+		return Manifest{}, err
+	}
+	meta, err := ReadRepoMeta(m.cfg.Path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	if err = writeFrame(bw, frameHeader, &incrementalHeader{Since: since, Manifest: cur, Keys: meta.Keys}); err != nil {
+		return Manifest{}, err
+	}
+	trailer := &incrementalTrailer{}
+	starts := make(map[uint32]uint64)
+	reset := make(map[uint32]bool)
+	for _, id := range ids {
+		mark := cur.Casks[id]
+		prev, ok := since.Casks[id]
+		if ok && prev.Gen == mark.Gen && prev.Size <= mark.Size {
+			starts[id] = prev.Size
+		} else {
+			reset[id] = true
+		}
+		if !reset[id] && mark.Size == starts[id] {
+			continue
+		}
+		seg := &segmentHeader{
+			Cask:   id,
+			Gen:    mark.Gen,
+			Offset: starts[id],
+			Size:   mark.Size - starts[id],
+			Reset:  reset[id],
+		}
+		if err = m.writeSegment(bw, seg, vlogs); err != nil {
+			return Manifest{}, err
+		}
+		trailer.Segments++
+		trailer.Bytes += seg.Size
+	}
+
+	// inMarks tells whether the value an entry points at lies below the
+	// marks, for shared values the one of their digest record
+	inMarks := func(key []byte, hlv *HintLV) bool {
+		id := m.fileID(string(key))
+		if hlv.Digest != nil {
+			if mark, ok := cur.Casks[id]; !ok || hlv.VOffset > mark.Size {
+				return false
+			}
+			rec, err := getDigest(snap, hlv.Digest)
+			if err != nil {
+				return false
+			}
+			id, hlv = rec.Cask, rec.hintLV()
+		}
+		mark, ok := cur.Casks[id]
+		return ok && hlv.VOffset+uint64(hlv.VSize) <= mark.Size
+	}
+	// the chunks of a value sort before its entry, which is left to the
+	// next backup unless they all made it into this one
+	within := func(key []byte) bool {
+		d, err := snap.Get(key, nil)
+		if err != nil {
+			return false
+		}
+		hlv, err := HintLVFromBytes(d)
+		return err == nil && inMarks(key, hlv)
+	}
+	// digest records sent along with the entries
+	digests := make(map[string]bool)
+	// complete tells whether everything an entry points at made it into
+	// this backup
+	complete := func(key []byte, hlv *HintLV) bool {
+		if hlv.Chunks == nil {
+			return inMarks(key, hlv)
+		}
+		for i := uint32(0); i < hlv.Chunks.Count; i++ {
+			if !within([]byte(chunkKey(hlv.Chunks.ID, i, entryKey(string(key))))) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, id := range ids {
+		if reset[id] {
+			continue
+		}
+		iter := snap.NewIterator(deleteLogRange(id), nil)
+		for iter.Next() {
+			gen, pos, key := parseDeleteLogKey(iter.Key())
+			if gen != cur.Casks[id].Gen || pos < starts[id] {
+				continue
+			}
+			typ, entry := byte(frameIndexDelete), &indexEntry{Key: []byte(key)}
+			if _, _, ok := parseVersionKey(entry.Key); ok {
+				// version records are journaled when written as well,
+				// whichever the index holds now goes
+				if entry.Value, err = snap.Get(entry.Key, nil); err == nil {
+					hlv, herr := HintLVFromBytes(entry.Value)
+					if herr != nil || !complete(entry.Key, hlv) {
+						continue
+					}
+					typ = frameIndexPut
+					if hlv.Digest != nil {
+						digests[string(hlv.Digest)] = true
+					}
+				} else if err != leveldb.ErrNotFound {
+					iter.Release()
+					return Manifest{}, err
+				}
+			}
+			if err = writeFrame(bw, typ, entry); err != nil {
+				iter.Release()
+				return Manifest{}, err
+			}
+			if typ == frameIndexPut {
+				trailer.Puts++
+			} else {
+				trailer.Deletes++
+			}
+		}
+		iter.Release()
+		if err = iter.Error(); err != nil {
+			return Manifest{}, err
+		}
+	}
+
+	for _, rng := range valueRanges() {
+		iter := snap.NewIterator(rng, nil)
+		for iter.Next() {
+			id := m.fileID(string(iter.Key()))
+			if _, ok := cur.Casks[id]; !ok {
+				continue
+			}
+			hlv, err := HintLVFromBytes(iter.Value())
+			if err != nil {
+				iter.Release()
+				return Manifest{}, err
+			}
+			// entries of chunked values point at no vlog, they go with
+			// every backup once complete
+			if hlv.Chunks == nil && !reset[id] && hlv.VOffset < starts[id] {
+				continue
+			}
+			if !complete(iter.Key(), hlv) {
+				continue
+			}
+			if hlv.Digest != nil {
+				digests[string(hlv.Digest)] = true
+			}
+			if err = writeFrame(bw, frameIndexPut, &indexEntry{Key: iter.Key(), Value: iter.Value()}); err != nil {
+				iter.Release()
+				return Manifest{}, err
+			}
+			trailer.Puts++
+		}
+		iter.Release()
+		if err = iter.Error(); err != nil {
+			return Manifest{}, err
+		}
+	}
+
+	// as are those whose value was appended or moved since
+	iter := snap.NewIterator(sysRange(nsDigest), nil)
+	for iter.Next() {
+		rec, err := digestFromBytes(iter.Value())
+		if err != nil {
+			iter.Release()
+			return Manifest{}, err
+		}
+		mark, ok := cur.Casks[rec.Cask]
+		if !ok || rec.VOffset+uint64(rec.VSize) > mark.Size {
+			continue
+		}
+		if !digests[sysKeySuffix(iter.Key())] && !reset[rec.Cask] && rec.VOffset < starts[rec.Cask] {
+			continue
+		}
+		if err = writeFrame(bw, frameIndexPut, &indexEntry{Key: iter.Key(), Value: iter.Value()}); err != nil {
+			iter.Release()
+			return Manifest{}, err
+		}
+		trailer.Puts++
+	}
+	iter.Release()
+	if err = iter.Error(); err != nil {
+		return Manifest{}, err
+	}
+	if err = writeFrame(bw, frameTrailer, trailer); err != nil {
+		return Manifest{}, err
+	}
+	return cur, bw.Flush()
+}
+
+func (m *mutcask) writeSegment(w io.Writer, seg *segmentHeader, vlogs map[uint32]os.FileInfo) error {
+	if err := writeFrame(w, frameSegment, seg); err != nil {
+		return err
+	}
+	if seg.Size == 0 {
+		return nil
+	}
+	fh, err := m.openVLog(vlogs, seg.Cask)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	_, err = io.Copy(w, io.NewSectionReader(fh, int64(seg.Offset), int64(seg.Size)))
+	return err
+}
+
+// TrimDeleteLog drops journaled deletes a backup chain ending with since
+// does not need anymore
+func (m *mutcask) TrimDeleteLog(since Manifest) error {
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	batch := new(leveldb.Batch)
+	for _, id := range m.caskIDs() {
+		mark, ok := since.Casks[id]
+		if !ok {
+			continue
+		}
+		iter := m.keys.NewIterator(deleteLogRange(id), nil)
+		for iter.Next() {
+			gen, pos, _ := parseDeleteLogKey(iter.Key())
+			if gen < mark.Gen || (gen == mark.Gen && pos < mark.Size) {
+				batch.Delete(iter.Key())
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	return m.keys.Write(batch, nil)
+}
+
+// RestoreBackups replays a chain of backups written by IncrementalBackup into
+// the repo at dir, which no process may hold open. The chain starts with a
+// full backup unless dir holds the restore of its earlier part. It returns
+// the manifest of the last backup applied.
+func RestoreBackups(dir string, backups ...io.Reader) (Manifest, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Manifest{}, err
+	}
+	unlockRepo, err := fslock.Lock(dir, lockFileName)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("could not lock the repo: %w", err)
+	}
+	defer unlockRepo.Close()
+	db, err := leveldb.OpenFile(filepath.Join(dir, keys_dir), nil)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer db.Close()
+
+	var last Manifest
+	for _, r := range backups {
+		applied, err := applyBackup(dir, db, r)
+		if err != nil {
+			return last, err
+		}
+		last = applied
+	}
+	return last, nil
+}
+
+func applyBackup(dir string, db *leveldb.DB, r io.Reader) (Manifest, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(incrementalMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, incrementalMagic) {
+		return Manifest{}, ErrBackupFormat
+	}
+	header := &incrementalHeader{}
+	if err := readFrame(br, frameHeader, header); err != nil {
+		return Manifest{}, err
+	}
+	if len(header.Since.Casks) > 0 {
+		applied, err := loadRestoredManifest(db)
+		if err != nil {
+			return Manifest{}, err
+		}
+		if !sameManifest(applied, &header.Since) {
+			return Manifest{}, ErrBackupChain
+		}
+	}
+	caskNum := header.Manifest.CaskNum
+	meta, err := ReadRepoMeta(dir)
+	switch {
+	case err == nil && meta.CaskNum != caskNum:
+		return Manifest{}, ErrCaskNumMismatch
+	case os.IsNotExist(err):
+		err = writeRepoMeta(dir, &RepoMeta{Version: RepoVersion, CaskNum: caskNum, Keys: header.Keys})
+	case err == nil && len(header.Keys) > len(meta.Keys):
+		// keys were added since the previous backup, the list only grows
+		meta.Version, meta.Keys = RepoVersion, header.Keys
+		err = writeRepoMeta(dir, meta)
+	}
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	batch := new(leveldb.Batch)
+	for {
+		typ, payload, err := readFramePayload(br)
+		if err != nil {
+			return Manifest{}, err
+		}
+		entry := &indexEntry{}
+		switch typ {
+		case frameSegment:
+			seg := &segmentHeader{}
+			if err := unmarshalFrame(payload, seg); err != nil {
+				return Manifest{}, err
+			}
+			if err := applySegment(dir, db, caskNum, seg, br); err != nil {
+				return Manifest{}, err
+			}
+		case frameIndexDelete:
+			if err := unmarshalFrame(payload, entry); err != nil {
+				return Manifest{}, err
+			}
+			batch.Delete(entry.Key)
+		case frameIndexPut:
+			if err := unmarshalFrame(payload, entry); err != nil {
+				return Manifest{}, err
+			}
+			batch.Put(entry.Key, entry.Value)
+			// the expiry index is not carried, stale records are skipped.
+			// Digest and version records expire with no key.
+			if len(entry.Key) > 1 && entry.Key[0] == sysPrefix && (entry.Key[1] == nsDigest || entry.Key[1] == nsVersion) {
+				continue
+			}
+			if hlv, err := HintLVFromBytes(entry.Value); err == nil && hlv.Expires != 0 {
+				batch.Put(expiryKey(hlv.Expires, string(entry.Key)), nil)
+			}
+		case frameTrailer:
+			md, err := cbor.Marshal(&header.Manifest)
+			if err != nil {
+				return Manifest{}, err
+			}
+			batch.Put(restoredManifestKey, md)
+			if err = db.Write(batch, nil); err != nil {
+				return Manifest{}, err
+			}
+			return header.Manifest, recountRefs(db)
+		default:
+			return Manifest{}, ErrBackupFormat
+		}
+	}
+}
+
+func applySegment(dir string, db *leveldb.DB, caskNum uint32, seg *segmentHeader, r io.Reader) error {
+	path := filepath.Join(dir, VLogName(seg.Cask))
+	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if seg.Reset {
+		if err = fh.Truncate(0); err != nil {
+			return err
+		}
+		// entries and digest records of the old generation point into the
+		// discarded vlog
+		batch := new(leveldb.Batch)
+		for _, rng := range valueRanges() {
+			iter := db.NewIterator(rng, nil)
+			for iter.Next() {
+				if caskID(string(iter.Key()), caskNum) == seg.Cask {
+					batch.Delete(iter.Key())
+				}
+			}
+			iter.Release()
+			if err = iter.Error(); err != nil {
+				return err
+			}
+		}
+		iter := db.NewIterator(sysRange(nsDigest), nil)
+		for iter.Next() {
+			if rec, err := digestFromBytes(iter.Value()); err != nil || rec.Cask == seg.Cask {
+				batch.Delete(iter.Key())
+			}
+		}
+		iter.Release()
+		if err = iter.Error(); err != nil {
+			return err
+		}
+		batch.Put(caskGenKey(seg.Cask), encodeUint64(seg.Gen))
+		if err = db.Write(batch, nil); err != nil {
+			return err
+		}
+	} else {
+		gen, err := loadCaskGen(db, seg.Cask)
+		if err != nil {
+			return err
+		}
+		size, err := fileSize(fh)
+		if err != nil {
+			return err
+		}
+		if gen != seg.Gen || size != seg.Offset {
+			return fmt.Errorf("cask %d at generation %d offset %d, backup continues generation %d offset %d: %w",
+				seg.Cask, gen, size, seg.Gen, seg.Offset, ErrBackupChain)
+		}
+	}
+	if _, err = fh.Seek(int64(seg.Offset), io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.CopyN(fh, r, int64(seg.Size)); err != nil {
+		return unexpectedEOF(err)
+	}
+	return fh.Sync()
+}
+
+func unmarshalFrame(payload []byte, v interface{}) error {
+	if err := cbor.Unmarshal(payload, v); err != nil {
+		return ErrBackupFormat
+	}
+	return nil
+}
+
+var restoredManifestKey = sysKey(nsBackup, "restored")
+
+// journalKey is set once the repo took an incremental backup, deletes and
+// version records are journaled from then on
+var journalKey = sysKey(nsBackup, "journal")
+
+func (m *mutcask) journaled() (bool, error) {
+	db, release := m.index()
+	defer release()
+	_, err := db.Get(journalKey, nil)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// startJournal has every cask journal from the next action it takes on, the
+// marks of the backup are taken after
+func (m *mutcask) startJournal() error {
+	if !m.journaling.Load() {
+		if err := m.keys.Put(journalKey, nil, nil); err != nil {
+			return err
+		}
+		m.journaling.Store(true)
+	}
+	for _, id := range m.caskIDs() {
+		cask, _ := m.caskMap.Get(id)
+		retvc := make(chan retv)
+		if err := cask.submit(context.Background(), &action{optype: opjournal, retvchan: retvc}); err != nil {
+			return err
+		}
+		if ret := <-retvc; ret.err != nil {
+			return ret.err
+		}
+	}
+	return nil
+}
+
+func loadRestoredManifest(db *leveldb.DB) (*Manifest, error) {
+	d, err := db.Get(restoredManifestKey, nil)
+	if err == leveldb.ErrNotFound {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	mf := &Manifest{}
+	return mf, cbor.Unmarshal(d, mf)
+}
+
+func sameManifest(a, b *Manifest) bool {
+	if a.Created != b.Created || a.CaskNum != b.CaskNum || len(a.Casks) != len(b.Casks) {
+		return false
+	}
+	for id, mark := range a.Casks {
+		if b.Casks[id] != mark {
+			return false
+		}
+	}
+	return true
+}
+
+func caskGenKey(id uint32) []byte {
+	return sysKey(nsGen, VLogName(id))
+}
+
+func loadCaskGen(db *leveldb.DB, id uint32) (uint64, error) {
+	d, err := db.Get(caskGenKey(id), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(d) != 8 {
+		return 0, ErrHintFormat
+	}
+	return binary.BigEndian.Uint64(d), nil
+}
+
+func encodeUint64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+/**
+	delete log key
+		prefix	:	cask id	:	generation	:	vlog size	:	key
+		3		:	4		:	8			:	8			:	xxxx
+**/
+func deleteLogKey(id uint32, gen, pos uint64, key string) []byte {
+	k := sysKey(nsDeleteLog, "")
+	k = binary.BigEndian.AppendUint32(k, id)
+	k = binary.BigEndian.AppendUint64(k, gen)
+	k = binary.BigEndian.AppendUint64(k, pos)
+	return append(k, key...)
+}
+
+func deleteLogRange(id uint32) *util.Range {
+	return util.BytesPrefix(binary.BigEndian.AppendUint32(sysKey(nsDeleteLog, ""), id))
+}
+
+func parseDeleteLogKey(k []byte) (gen, pos uint64, key string) {
+	k = k[3+4:]
+	return binary.BigEndian.Uint64(k), binary.BigEndian.Uint64(k[8:]), string(k[16:])
+}
@@ -0,0 +1,167 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIncrementalBackup(t *testing.T) {
+	mutc, err := NewMutcask(PathConf(tmpdirpath(t)), CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mutc.Close()
+	for i := 0; i < 50; i++ {
+		if err := mutc.Put(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("value-%03d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var backups []*bytes.Buffer
+	backup := func(since Manifest) Manifest {
+		buf := bytes.NewBuffer(nil)
+		mf, err := mutc.IncrementalBackup(since, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backups = append(backups, buf)
+		return mf
+	}
+	mf := backup(Manifest{})
+
+	// overwrite, delete and add keys
+	for i := 0; i < 10; i++ {
+		if err := mutc.Put(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("again-%03d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if err := mutc.Delete(fmt.Sprintf("key-%03d", i+10)); err != nil {
+			t.Fatal(err)
+		}
+		if err := mutc.Put(fmt.Sprintf("key-%03d", i+50), []byte(fmt.Sprintf("value-%03d", i+50))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mf = backup(mf)
+	full := backups[0].Len()
+	if backups[1].Len() >= full {
+		t.Fatalf("incremental backup should be smaller than the full one, %d >= %d", backups[1].Len(), full)
+	}
+
+	// a compacted cask is sent whole
+	if err := mutc.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mutc.Delete("key-020"); err != nil {
+		t.Fatal(err)
+	}
+	backup(mf)
+
+	dir := filepath.Join(tmpdirpath(t), "restored")
+	readers := []io.Reader{}
+	for _, b := range backups {
+		readers = append(readers, bytes.NewReader(b.Bytes()))
+	}
+	if _, err := RestoreBackups(dir, readers...); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewMutcask(PathConf(dir), CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	want, err := mutc.ListKeys("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := restored.ListKeys("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != len(got) || len(got) != 49 {
+		t.Fatalf("restored %d keys, expected %d", len(got), len(want))
+	}
+	for _, key := range want {
+		wv, _ := mutc.Get(key)
+		gv, err := restored.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wv, gv) {
+			t.Fatalf("%s should equal to %s", gv, wv)
+		}
+	}
+
+	// the chain can not skip a backup
+	_, err = RestoreBackups(filepath.Join(tmpdirpath(t), "broken"), bytes.NewReader(backups[0].Bytes()), bytes.NewReader(backups[2].Bytes()))
+	if err == nil {
+		t.Fatal("restoring a broken chain should fail")
+	}
+}
+
+func TestDeleteJournal(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal := func() int {
+		n := 0
+		for _, id := range m.caskIDs() {
+			iter := m.keys.NewIterator(deleteLogRange(id), nil)
+			for iter.Next() {
+				n++
+			}
+			iter.Release()
+		}
+		return n
+	}
+	for i := 0; i < 4; i++ {
+		if err := m.Put(fmt.Sprint(i), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// nothing is journaled before the first backup
+	if err := m.Delete("0"); err != nil {
+		t.Fatal(err)
+	}
+	if n := journal(); n != 0 {
+		t.Fatalf("%d deletes journaled without backups", n)
+	}
+	mf, err := m.IncrementalBackup(Manifest{}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and everything after, reopened or not
+	if err := m.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	if m, err = NewMutcask(PathConf(dir), CaskNumConf(2)); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Delete("2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := journal(); n != 2 {
+		t.Fatalf("%d deletes journaled, want 2", n)
+	}
+
+	// a repo which never journaled can not continue a chain
+	odir := tmpdirpath(t)
+	defer os.RemoveAll(odir)
+	other, err := NewMutcask(PathConf(odir), CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.IncrementalBackup(mf, io.Discard); err != ErrNoJournal {
+		t.Fatalf("backup without journal: %v", err)
+	}
+}
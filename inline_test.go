@@ -0,0 +1,111 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInlineValues(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(1), InlineConf(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	big := bytes.Repeat([]byte("stored in the vlog "), 10)
+	if err := m.Put("big", big); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	since, err := m.IncrementalBackup(Manifest{}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups := []*bytes.Buffer{buf}
+	vlogBytes := func() uint64 {
+		st, err := m.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.VLogBytes
+	}
+	size := vlogBytes()
+	if err := m.Put("small", []byte("value1")); err != nil {
+		t.Fatal(err)
+	}
+	if vlogBytes() != size {
+		t.Fatal("small value appended to the vlog")
+	}
+	if st, err := m.Stat("small"); err != nil || !st.Inline || st.Size != 6 {
+		t.Fatalf("stat %+v: %v", st, err)
+	}
+
+	// served without the vlog
+	vlog := filepath.Join(dir, VLogName(0))
+	saved, err := os.ReadFile(vlog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(vlog); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := m.Get("small"); err != nil || string(v) != "value1" {
+		t.Fatalf("get %q: %v", v, err)
+	}
+	var out bytes.Buffer
+	if _, err := m.Read("small", &out); err != nil || out.String() != "value1" {
+		t.Fatalf("read %q: %v", out.String(), err)
+	}
+	out.Reset()
+	if _, err := m.ReadRange("small", &out, 2, 3); err != nil || out.String() != "lue" {
+		t.Fatalf("read range %q: %v", out.String(), err)
+	}
+	if n, err := m.Size("small"); err != nil || n != 6 {
+		t.Fatalf("size %d: %v", n, err)
+	}
+	if _, err := m.CheckSum("small"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("big"); err == nil {
+		t.Fatal("vlog value read without its vlog")
+	}
+	if err := os.WriteFile(vlog, saved, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// compaction leaves inline values alone, backups carry them
+	if err := m.Put("big", []byte("now small")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if vlogBytes() != 0 {
+		t.Fatal("vlog not reclaimed")
+	}
+	buf = new(bytes.Buffer)
+	if _, err = m.IncrementalBackup(since, buf); err != nil {
+		t.Fatal(err)
+	}
+	backups = append(backups, buf)
+	restored := filepath.Join(tmpdirpath(t), "restored")
+	defer os.RemoveAll(filepath.Dir(restored))
+	if _, err := RestoreBackups(restored, backups[0], backups[1]); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewMutcask(PathConf(restored), CaskNumConf(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for k, want := range map[string]string{"small": "value1", "big": "now small"} {
+		if v, err := r.Get(k); err != nil || string(v) != want {
+			t.Fatalf("restored %s %q: %v", k, v, err)
+		}
+	}
+}
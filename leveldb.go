@@ -83,7 +83,11 @@ func (kv *levedbKV) AllKeysChan(ctx context.Context) (chan string, error) {
 			if !iter.Next() {
 				return
 			}
-			out <- string(iter.Key())
+			select {
+			case <-ctx.Done():
+				return
+			case out <- string(iter.Key()):
+			}
 		}
 		// Todo: log if has iter.Error()
 	}(iter, out)
@@ -0,0 +1,16 @@
+package mutcask
+
+import (
+	"context"
+	"log/slog"
+)
+
+// nopLogger is used unless a logger is configured
+var nopLogger = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,45 @@
+package mutcask
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateLogsSummary(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	var hints []byte
+	for i := 0; i < 10; i++ {
+		h := &Hint{Key: fmt.Sprintf("key-%d", i), VOffset: uint64(i * 10), VSize: 10}
+		d, err := h.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hints = append(hints, d...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "00000000"+hintLogSuffix), hints, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(2), MigrateConf(), LoggerConf(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if _, err := m.Size("key-3"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logs.String(), "key-3") {
+		t.Fatalf("keys logged one by one:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "migrated=10 failed=0") {
+		t.Fatalf("missing migration summary:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,109 @@
+package mutcask
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const metaFileName = "repo.meta"
+
+// current layout version of a repo, version 2 repos may hold compressed or
+// sealed records which older releases would return as they are, version 3
+// ones index entries pointing at shared values, version 4 ones at chunks,
+// version 5 ones holding values, version 6 ones the versions of values
+const RepoVersion = 6
+
+// RepoMeta is kept in the repo root and records settings the on-disk layout
+// depends on, keys are routed to casks by CaskNum so it can not change once
+// data has been written.
+type RepoMeta struct {
+	Version int
+	CaskNum uint32
+	// keys records were sealed with, see keyring
+	Keys []KeyInfo `json:",omitempty"`
+}
+
+func ReadRepoMeta(dir string) (*RepoMeta, error) {
+	d, err := os.ReadFile(filepath.Join(dir, metaFileName))
+	if err != nil {
+		return nil, err
+	}
+	meta := &RepoMeta{}
+	if err = json.Unmarshal(d, meta); err != nil {
+		return nil, ErrRepoMeta
+	}
+	return meta, nil
+}
+
+func writeRepoMeta(dir string, meta *RepoMeta) error {
+	d, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, metaFileName+".tmp")
+	if err = os.WriteFile(tmp, d, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, metaFileName))
+}
+
+// loadRepoMeta checks the config against the recorded metadata, repos created
+// before the metadata existed get it written on first open by a writer
+func loadRepoMeta(cfg *Config) error {
+	meta, err := ReadRepoMeta(cfg.Path)
+	if err == nil {
+		if meta.Version > RepoVersion {
+			return ErrRepoVersion
+		}
+		if meta.CaskNum != cfg.CaskNum {
+			return ErrCaskNumMismatch
+		}
+		if meta.Version < RepoVersion && !cfg.ReadOnly && (cfg.Compression != CompressNone || cfg.KeyProvider != nil || cfg.Dedup || cfg.ChunkSize > 0 || cfg.InlineSize > 0 || cfg.versioned()) {
+			meta.Version = RepoVersion
+			return writeRepoMeta(cfg.Path, meta)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	ids, err := vLogIDs(cfg.Path)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id >= cfg.CaskNum {
+			return ErrCaskNumMismatch
+		}
+	}
+	if cfg.ReadOnly {
+		return nil
+	}
+	return writeRepoMeta(cfg.Path, &RepoMeta{
+		Version: RepoVersion,
+		CaskNum: cfg.CaskNum,
+	})
+}
+
+// vLogIDs lists the cask ids of vlog files within dir
+func vLogIDs(dir string) ([]uint32, error) {
+	dirents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, ent := range dirents {
+		if ent.IsDir() || !strings.HasSuffix(ent.Name(), vLogSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(ent.Name(), vLogSuffix), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
@@ -0,0 +1,275 @@
+// Package metrics exports Prometheus metrics of a mutcask KVDB. New wraps
+// the KVDB to count and time its operations and registers a collector
+// reading the repo gauges on every scrape.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/filedag-project/mutcask"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "mutcask"
+
+// the dead ratio walks the whole index, scrapes within this interval reuse
+// the last one unless configured otherwise
+const DefaultStatsInterval = time.Minute
+
+const (
+	opPut      = "put"
+	opGet      = "get"
+	opRead     = "read"
+	opDelete   = "delete"
+	opCheckSum = "checksum"
+)
+
+type statser interface {
+	Stats() (*mutcask.Stats, error)
+}
+
+type gaugeser interface {
+	Gauges() *mutcask.Gauges
+}
+
+type cacheStatser interface {
+	CacheStats() (hits, misses uint64)
+}
+
+// DB is a KVDB recording the operations going through it
+type DB struct {
+	db       mutcask.KVDB
+	ops      *prometheus.CounterVec
+	errs     *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+var _ mutcask.KVDB = (*DB)(nil)
+
+type Options struct {
+	// how long a dead ratio is reused, DefaultStatsInterval when zero
+	StatsInterval time.Duration
+}
+
+// New registers the metrics of db with reg and returns db wrapped to feed
+// them, operations not done through the returned DB are not counted
+func New(db mutcask.KVDB, reg prometheus.Registerer, opts *Options) (*DB, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	m := &DB{
+		db: db,
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Operations by type.",
+		}, []string{"op"}),
+		errs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_total",
+			Help:      "Failed operations by type and error.",
+		}, []string{"op", "error"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of operations by type.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"op"}),
+	}
+	interval := opts.StatsInterval
+	if interval == 0 {
+		interval = DefaultStatsInterval
+	}
+	cs := []prometheus.Collector{m.ops, m.errs, m.duration, newCollector(db, interval)}
+	for _, c := range cs {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// observe records an operation started at start
+func (m *DB) observe(op string, start time.Time, err error) {
+	m.ops.WithLabelValues(op).Inc()
+	m.duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.errs.WithLabelValues(op, errorLabel(err)).Inc()
+	}
+}
+
+func errorLabel(err error) string {
+	switch {
+	case errors.Is(err, mutcask.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, mutcask.ErrDataRotted):
+		return "data_rotted"
+	default:
+		return "other"
+	}
+}
+
+func (m *DB) Put(key string, value []byte) error {
+	start := time.Now()
+	err := m.db.Put(key, value)
+	m.observe(opPut, start, err)
+	return err
+}
+
+func (m *DB) Get(key string) ([]byte, error) {
+	start := time.Now()
+	v, err := m.db.Get(key)
+	m.observe(opGet, start, err)
+	return v, err
+}
+
+func (m *DB) Read(key string, w io.Writer) (int, error) {
+	start := time.Now()
+	n, err := m.db.Read(key, w)
+	m.observe(opRead, start, err)
+	return n, err
+}
+
+// ReadRange is counted as a read
+func (m *DB) ReadRange(key string, w io.Writer, offset, length int64) (int, error) {
+	start := time.Now()
+	n, err := mutcask.ReadRange(m.db, key, w, offset, length)
+	m.observe(opRead, start, err)
+	return n, err
+}
+
+func (m *DB) Delete(key string) error {
+	start := time.Now()
+	err := m.db.Delete(key)
+	m.observe(opDelete, start, err)
+	return err
+}
+
+func (m *DB) CheckSum(key string) (string, error) {
+	start := time.Now()
+	sum, err := m.db.CheckSum(key)
+	m.observe(opCheckSum, start, err)
+	return sum, err
+}
+
+func (m *DB) Size(key string) (int, error) {
+	return m.db.Size(key)
+}
+
+func (m *DB) AllKeysChan(ctx context.Context) (chan string, error) {
+	return m.db.AllKeysChan(ctx)
+}
+
+// Sync is passed on uncounted
+func (m *DB) Sync() error {
+	return mutcask.Sync(m.db)
+}
+
+func (m *DB) Close() error {
+	return m.db.Close()
+}
+
+// Unwrap returns the KVDB given to New
+func (m *DB) Unwrap() mutcask.KVDB {
+	return m.db
+}
+
+// collector reads the gauges of the repo when scraped
+type collector struct {
+	db       mutcask.KVDB
+	interval time.Duration
+
+	queueDepth    *prometheus.Desc
+	vlogBytes     *prometheus.Desc
+	liveBytes     *prometheus.Desc
+	logicalBytes  *prometheus.Desc
+	deadRatio     *prometheus.Desc
+	cacheHits     *prometheus.Desc
+	cacheMisses   *prometheus.Desc
+	compactCasks  *prometheus.Desc
+	compactDone   *prometheus.Desc
+	compactTotal  *prometheus.Desc
+	compactCopied *prometheus.Desc
+	statsLock     sync.Mutex
+	stats         *mutcask.Stats
+	statsTime     time.Time
+}
+
+func newCollector(db mutcask.KVDB, interval time.Duration) *collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+	return &collector{
+		db:            db,
+		interval:      interval,
+		queueDepth:    desc("cask_queue_depth", "Actions waiting for the cask goroutine.", "cask"),
+		vlogBytes:     desc("cask_vlog_bytes", "Size of the cask vlog.", "cask"),
+		liveBytes:     desc("live_bytes", "Vlog bytes still referenced by a key, as of the last stats walk."),
+		logicalBytes:  desc("logical_bytes", "Live bytes before compression, as of the last stats walk."),
+		deadRatio:     desc("dead_ratio", "Share of vlog bytes compaction could reclaim, as of the last stats walk."),
+		cacheHits:     desc("cache_hits_total", "Gets answered from the cache."),
+		cacheMisses:   desc("cache_misses_total", "Gets which missed the cache."),
+		compactCasks:  desc("compaction_casks", "Casks the running compaction goes through."),
+		compactDone:   desc("compaction_casks_done", "Casks the running compaction went through."),
+		compactTotal:  desc("compaction_bytes", "Live bytes of the cask being compacted."),
+		compactCopied: desc("compaction_bytes_copied", "Live bytes of the cask being compacted copied so far."),
+	}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		c.queueDepth, c.vlogBytes, c.liveBytes, c.logicalBytes, c.deadRatio, c.cacheHits,
+		c.cacheMisses, c.compactCasks, c.compactDone, c.compactTotal, c.compactCopied,
+	} {
+		ch <- d
+	}
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	if g, ok := c.db.(gaugeser); ok {
+		gauges := g.Gauges()
+		for _, cs := range gauges.Casks {
+			id := strconv.FormatUint(uint64(cs.ID), 10)
+			ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(cs.Pending), id)
+			ch <- prometheus.MustNewConstMetric(c.vlogBytes, prometheus.GaugeValue, float64(cs.VLogBytes), id)
+		}
+		cp := gauges.Compaction
+		ch <- prometheus.MustNewConstMetric(c.compactCasks, prometheus.GaugeValue, float64(cp.CasksTotal))
+		ch <- prometheus.MustNewConstMetric(c.compactDone, prometheus.GaugeValue, float64(cp.CasksDone))
+		ch <- prometheus.MustNewConstMetric(c.compactTotal, prometheus.GaugeValue, float64(cp.BytesTotal))
+		ch <- prometheus.MustNewConstMetric(c.compactCopied, prometheus.GaugeValue, float64(cp.BytesCopied))
+	}
+	if st := c.recentStats(); st != nil {
+		ch <- prometheus.MustNewConstMetric(c.liveBytes, prometheus.GaugeValue, float64(st.LiveBytes))
+		ch <- prometheus.MustNewConstMetric(c.logicalBytes, prometheus.GaugeValue, float64(st.LogicalBytes))
+		ch <- prometheus.MustNewConstMetric(c.deadRatio, prometheus.GaugeValue, st.DeadRatio())
+	}
+	if cs, ok := c.db.(cacheStatser); ok {
+		hits, misses := cs.CacheStats()
+		ch <- prometheus.MustNewConstMetric(c.cacheHits, prometheus.CounterValue, float64(hits))
+		ch <- prometheus.MustNewConstMetric(c.cacheMisses, prometheus.CounterValue, float64(misses))
+	}
+}
+
+// recentStats walks the index at most once per interval
+func (c *collector) recentStats() *mutcask.Stats {
+	s, ok := c.db.(statser)
+	if !ok {
+		return nil
+	}
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	if c.stats == nil || time.Since(c.statsTime) >= c.interval {
+		st, err := s.Stats()
+		if err != nil {
+			return c.stats
+		}
+		c.stats, c.statsTime = st, time.Now()
+	}
+	return c.stats
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/filedag-project/mutcask"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	db, err := mutcask.NewMutcask(mutcask.PathConf(t.TempDir()), mutcask.CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	m, err := New(db, reg, &Options{StatsInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := m.Put(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Put("a", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("missing"); err != mutcask.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if n := testutil.ToFloat64(m.ops.WithLabelValues(opPut)); n != 4 {
+		t.Fatalf("expected 4 puts, got %v", n)
+	}
+	if n := testutil.ToFloat64(m.errs.WithLabelValues(opGet, "not_found")); n != 1 {
+		t.Fatalf("expected 1 not found, got %v", n)
+	}
+	if n := testutil.CollectAndCount(m.duration); n != 2 {
+		t.Fatalf("expected latencies of 2 operations, got %d", n)
+	}
+
+	found := gather(t, reg)
+	// 4 records of 5 bytes and a crc each
+	if found["mutcask_cask_vlog_bytes"] != 36 {
+		t.Fatalf("unexpected vlog bytes %v", found["mutcask_cask_vlog_bytes"])
+	}
+	if r := found["mutcask_dead_ratio"]; r != 0.25 {
+		t.Fatalf("unexpected dead ratio %v", r)
+	}
+	if _, ok := found["mutcask_cask_queue_depth"]; !ok {
+		t.Fatal("queue depth not exported")
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	db, err := mutcask.NewCachedMutcask(16, mutcask.PathConf(t.TempDir()), mutcask.CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	m, err := New(db, reg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Put("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := m.Get("key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found := gather(t, reg)
+	if found["mutcask_cache_hits_total"] != 2 || found["mutcask_cache_misses_total"] != 1 {
+		t.Fatalf("unexpected cache hits %v misses %v", found["mutcask_cache_hits_total"], found["mutcask_cache_misses_total"])
+	}
+}
+
+// gather sums up the values of each gauge and counter
+func gather(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, metric := range mf.Metric {
+			if g := metric.GetGauge(); g != nil {
+				found[mf.GetName()] += g.GetValue()
+			}
+			if c := metric.GetCounter(); c != nil {
+				found[mf.GetName()] += c.GetValue()
+			}
+		}
+	}
+	return found
+}
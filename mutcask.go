@@ -1,6 +1,7 @@
 package mutcask
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -10,10 +11,13 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	fslock "github.com/ipfs/go-fs-lock"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const lockFileName = "repo.lock"
@@ -29,6 +33,28 @@ type mutcask struct {
 	close          func()
 	closeChan      chan struct{}
 	keys           *leveldb.DB
+	scrub          *scrubber
+	// serializes maintenance work such as compaction
+	maint sync.Mutex
+	// background workers, waited for before the index is closed
+	bg sync.WaitGroup
+	// casks gone through and to go through by a running compaction
+	compactDone  uint32
+	compactCasks uint32
+	// set in read-only mode instead of keys, see index
+	ro     *roIndex
+	tracer trace.Tracer
+	// set when values are sealed, see keyring
+	ring *keyring
+	// shared by the casks of a writer
+	dedup *deduper
+	// ids of chunked values being written, and whether the repo holds any
+	chunking sync.Map
+	chunked  atomic.Bool
+	// set once the repo holds keys with a TTL
+	expiring atomic.Bool
+	// set once the repo takes incremental backups, see startJournal
+	journaling atomic.Bool
 }
 
 func NewMutcask(opts ...Option) (*mutcask, error) {
@@ -40,10 +66,14 @@ func NewMutcask(opts ...Option) (*mutcask, error) {
 	for _, opt := range opts {
 		opt(m.cfg)
 	}
+	m.tracer = m.cfg.TracerProvider.Tracer(tracerName)
 	repoPath := m.cfg.Path
 	if repoPath == "" {
 		return nil, ErrPathUndefined
 	}
+	if m.cfg.ReadOnly {
+		return openReadOnly(m)
+	}
 	repo, err := os.Stat(repoPath)
 	if err == nil && !repo.IsDir() {
 		return nil, ErrPath
@@ -69,29 +99,110 @@ func NewMutcask(opts ...Option) (*mutcask, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not lock the repo: %w", err)
 	}
+	if err := loadRepoMeta(m.cfg); err != nil {
+		unlockRepo.Close()
+		return nil, err
+	}
+	if m.ring, err = openKeyring(m.cfg); err != nil {
+		unlockRepo.Close()
+		return nil, err
+	}
 	if m.cfg.InitBuf > 0 {
 		setInitBuf(m.cfg.InitBuf)
 	}
 	db, err := leveldb.OpenFile(filepath.Join(repoPath, keys_dir), nil)
 	if err != nil {
+		unlockRepo.Close()
 		return nil, err
 	}
 	m.keys = db
+	m.dedup = newDeduper(db)
+	m.chunked.Store(hasChunks(db))
+	m.expiring.Store(hasExpiry(db))
+	if _, err = db.Get(journalKey, nil); err == nil {
+		m.journaling.Store(true)
+	}
+	if err = recoverCompaction(repoPath, db, m.cfg.Logger); err != nil {
+		db.Close()
+		unlockRepo.Close()
+		return nil, err
+	}
 	m.caskMap, err = buildCaskMap(m.cfg, db)
 	if err != nil {
+		db.Close()
+		unlockRepo.Close()
 		return nil, err
 	}
+	for _, id := range m.caskIDs() {
+		cask, _ := m.caskMap.Get(id)
+		cask.ring = m.ring
+		cask.dedup = m.dedup
+		cask.versioned = m.cfg.versioned()
+		cask.journaling = m.journaling.Load()
+	}
+	m.scrub = &scrubber{m: m}
 	var once sync.Once
 	m.close = func() {
 		once.Do(func() {
+			// background workers may have actions queued, the casks
+			// are closed once they are done
 			close(m.closeChan)
+			m.bg.Wait()
+			m.caskMap.CloseAll()
+			m.keys.Close()
 			unlockRepo.Close()
 		})
 	}
 	if m.cfg.Migrate {
-		doMigrate(m.cfg, m.keys)
+		if err := doMigrate(m.cfg, m.keys); err != nil {
+			m.cfg.Logger.Error("migrate hint logs", "err", err)
+		}
 	}
 	m.handleCreateCask()
+	m.startScrubber()
+	m.startReaper()
+	return m, nil
+}
+
+// openReadOnly opens an existing repo without the repo lock, next to the
+// writer holding it
+func openReadOnly(m *mutcask) (*mutcask, error) {
+	repo, err := os.Stat(m.cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !repo.IsDir() {
+		return nil, ErrPath
+	}
+	if err := loadRepoMeta(m.cfg); err != nil {
+		return nil, err
+	}
+	ring, err := openKeyring(m.cfg)
+	if err != nil {
+		return nil, err
+	}
+	m.ring = ring
+	if m.cfg.InitBuf > 0 {
+		setInitBuf(m.cfg.InitBuf)
+	}
+	m.caskMap = &CaskMap{m: make(map[uint32]*Cask)}
+	db, vlogs, err := m.loadIndex()
+	if err != nil {
+		m.caskMap.CloseAll()
+		return nil, err
+	}
+	m.ro = &roIndex{cur: &indexRef{db: db, vlogs: vlogs}}
+	m.scrub = &scrubber{m: m}
+	var once sync.Once
+	m.close = func() {
+		once.Do(func() {
+			close(m.closeChan)
+			m.bg.Wait()
+			m.caskMap.CloseAll()
+			m.ro.swap(nil, nil)
+		})
+	}
+	m.startRefresher()
 	return m, nil
 }
 
@@ -110,9 +221,15 @@ func (m *mutcask) handleCreateCask() {
 						return
 					}
 					cask := NewCask(req.id, m.keys)
+					cask.log = m.cfg.Logger.With("cask", req.id)
+					cask.ring = m.ring
+					cask.dedup = m.dedup
+					cask.versioned = m.cfg.versioned()
+					cask.journaling = m.journaling.Load()
 					var err error
 					// create vlog file
-					cask.vLog, err = os.OpenFile(filepath.Join(m.cfg.Path, m.vLogName(req.id)), os.O_RDWR|os.O_CREATE, 0644)
+					cask.path = m.vLogPath(req.id)
+					cask.vLog, err = os.OpenFile(cask.path, os.O_RDWR|os.O_CREATE, 0644)
 					if err != nil {
 						req.done <- err
 						return
@@ -133,6 +250,10 @@ func (m *mutcask) handleCreateCask() {
 }
 
 func (m *mutcask) vLogName(id uint32) string {
+	return VLogName(id)
+}
+
+func VLogName(id uint32) string {
 	return fmt.Sprintf("%08d%s", id, vLogSuffix)
 }
 
@@ -140,58 +261,212 @@ func (m *mutcask) vLogName(id uint32) string {
 // 	return fmt.Sprintf("%08d%s", id, hintLogSuffix)
 // }
 
-func (m *mutcask) Put(key string, value []byte) (err error) {
-	id := m.fileID(key)
-	var cask *Cask
-	var has bool
-	cask, has = m.caskMap.Get(id)
-	if !has {
-		done := make(chan error)
-		m.createCaskChan <- &createCaskRequst{
-			id:   id,
-			done: done,
-		}
-		if err := <-done; err != ErrNone {
-			return err
+func (m *mutcask) vLogPath(id uint32) string {
+	return filepath.Join(m.cfg.Path, m.vLogName(id))
+}
+
+// PutOptions tune how a single value is stored
+type PutOptions struct {
+	// the key is gone once it has passed, zero keeps it until deleted
+	TTL time.Duration
+	// kept in the index entry, up to MaxMetaSize. It is replaced along with
+	// the value.
+	Meta map[string]string
+	// fail with ErrConflict when the key holds a value
+	IfAbsent bool
+	// fail with ErrConflict unless the key holds a value of this hex sha256
+	// checksum
+	IfMatch string
+	// record the sha256 of the value in the index entry, so Stat and
+	// CompareAndSwap need not read the value. Dedup repos always do.
+	Checksum bool
+}
+
+func (m *mutcask) Put(key string, value []byte) error {
+	return m.PutWithOptions(key, value, PutOptions{})
+}
+
+func (m *mutcask) PutWithOptions(key string, value []byte, opts PutOptions) (err error) {
+	ctx, span := m.startOp("Put", key)
+	span.SetAttributes(attrSize.Int(len(value)))
+	defer func() { endSpan(span, err) }()
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	attrs, err := m.putAttrs(opts)
+	if err != nil {
+		return err
+	}
+	if attrs.cond, err = m.writeCond(ctx, key, opts); err != nil {
+		return err
+	}
+	old := m.chunksOf(ctx, key)
+	if m.cfg.ChunkSize > 0 && len(value) > m.cfg.ChunkSize {
+		err = m.putChunks(ctx, key, bytes.NewReader(value), attrs)
+	} else {
+		err = m.put(ctx, key, value, attrs)
+	}
+	if err == nil && old != nil {
+		m.dropChunks(ctx, key, old)
+	}
+	return err
+}
+
+// put stores value under key, which may be internal
+func (m *mutcask) put(ctx context.Context, key string, value []byte, attrs entryAttrs) error {
+	if m.cfg.InlineSize > 0 && len(value) <= m.cfg.InlineSize && m.ring == nil {
+		return m.putEntry(ctx, key, &HintLV{Inline: true, Value: value}, attrs)
+	}
+	var digest []byte
+	if m.cfg.Dedup || attrs.wantSum {
+		sum := sha256.Sum256(value)
+		attrs.sum = sum[:]
+		if m.cfg.Dedup {
+			digest = sum[:]
 		}
-		cask, _ = m.caskMap.Get(id)
 	}
+	lsize := uint32(0)
+	if c := compressValue(value, m.cfg.Compression); c != nil {
+		value, lsize = c, uint32(len(value))
+	}
+	cask, err := m.cask(m.fileID(key))
+	if err != nil {
+		return err
+	}
+	return cask.put(ctx, key, value, lsize, digest, attrs)
+}
+
+func (m *mutcask) putEntry(ctx context.Context, key string, hlv *HintLV, attrs entryAttrs) error {
+	cask, err := m.cask(m.fileID(key))
+	if err != nil {
+		return err
+	}
+	return cask.putEntry(ctx, key, hlv, attrs)
+}
 
-	return cask.Put(key, value)
+// cask returns the cask with id, creating it on first use
+func (m *mutcask) cask(id uint32) (*Cask, error) {
+	cask, has := m.caskMap.Get(id)
+	if has {
+		return cask, nil
+	}
+	done := make(chan error)
+	select {
+	case m.createCaskChan <- &createCaskRequst{id: id, done: done}:
+	case <-m.closeChan:
+		return nil, ErrClosed
+	}
+	if err := <-done; err != ErrNone {
+		return nil, err
+	}
+	cask, _ = m.caskMap.Get(id)
+	return cask, nil
 }
 
-func (m *mutcask) Delete(key string) error {
+func (m *mutcask) Delete(key string) (err error) {
+	ctx, span := m.startOp("Delete", key)
+	defer func() { endSpan(span, err) }()
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
 	id := m.fileID(key)
 	cask, has := m.caskMap.Get(id)
 	if !has {
 		return nil
 	}
-	return cask.Delete(key)
+	old := m.chunksOf(ctx, key)
+	if err = cask.delete(ctx, key); err == nil && old != nil {
+		m.dropChunks(ctx, key, old)
+	}
+	return err
 }
 
-func (m *mutcask) Get(key string) ([]byte, error) {
-	hint, err := get_hint(m.keys, key)
-	if err != nil {
-		return nil, ErrNotFound
+func (m *mutcask) Get(key string) (v []byte, err error) {
+	ctx, span := m.startOp("Get", key)
+	defer func() { endSpan(span, err) }()
+	return m.getValue(ctx, key)
+}
+
+// getValue is Get within the span in ctx
+func (m *mutcask) getValue(ctx context.Context, key string) ([]byte, error) {
+	_, v, err := m.getHinted(ctx, key)
+	return v, err
+}
+
+// getHinted is getValue also returning the index entry the value was read
+// by
+func (m *mutcask) getHinted(ctx context.Context, key string) (*Hint, []byte, error) {
+	hint, v, err := m.get(ctx, key)
+	if m.ro != nil && (err == ErrDataRotted || err == ErrReadHintBeyondRange) {
+		// the writer may have compacted the vlog since the index was loaded
+		if m.Refresh() == nil {
+			hint, v, err = m.get(ctx, key)
+		}
 	}
-	id := m.fileID(key)
-	fp := filepath.Join(m.cfg.Path, m.vLogName(id))
+	return hint, v, err
+}
 
-	buf := vBuf.Get().(*vbuffer)
-	buf.size(int(hint.VSize))
-	defer vBuf.Put(buf)
+func (m *mutcask) get(ctx context.Context, key string) (*Hint, []byte, error) {
+	hint, _, fh, release, err := m.acquire(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hint.Inline {
+		release()
+		return hint, hint.Value, nil
+	}
+	if hint.Chunks != nil {
+		release()
+		var buf bytes.Buffer
+		buf.Grow(int(hint.Chunks.Total))
+		if _, err = m.readChunks(ctx, hint, &buf, 0, int64(hint.Chunks.Total)); err != nil {
+			return nil, nil, err
+		}
+		return hint, buf.Bytes(), nil
+	}
+	defer release()
+	v, err := m.readValue(ctx, fh, hint)
+	return hint, v, err
+}
 
-	fh, err := os.Open(fp)
+// readValue reads and decodes the whole value the hint points to, checking
+// its crc or for sealed records the tag
+func (m *mutcask) readValue(ctx context.Context, fh *os.File, hint *Hint) ([]byte, error) {
+	_, span := startSpan(ctx, "vlog.read", attrOffset.Int64(int64(hint.VOffset)), attrSize.Int64(int64(hint.VSize)))
+	buf, err := readRecord(fh, hint)
+	endSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
-	defer fh.Close()
-
-	_, err = fh.ReadAt(*buf, int64(hint.VOffset))
+	defer vBuf.Put(buf)
+	if hint.EncKey > 0 {
+		if m.ring == nil {
+			return nil, ErrKeyNotFound
+		}
+		// the tag covers what the crc would
+		_, span = startSpan(ctx, "aead.open", attrSize.Int64(int64(hint.VSize)))
+		plain, err := m.ring.open(hint.sealedWith(), hint.EncKey, (*buf)[4:])
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+		return decompressValue(plain, hint.LSize)
+	}
+	_, span = startSpan(ctx, "crc.verify", attrSize.Int64(int64(hint.VSize)))
+	err = VerifyValue(*buf)
+	endSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
-	v, err := DecodeValue(*buf, true)
+	var v []byte
+	if hint.LSize > 0 {
+		v, err = decompressValue((*buf)[4:], hint.LSize)
+	} else {
+		v, err = DecodeValue(*buf, false)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -199,59 +474,170 @@ func (m *mutcask) Get(key string) ([]byte, error) {
 	return v, nil
 }
 
-func (m *mutcask) Read(key string, w io.Writer) (int, error) {
-	hint, err := get_hint(m.keys, key)
-	if err != nil {
-		return 0, ErrNotFound
-	}
-	id := m.fileID(key)
-	fp := filepath.Join(m.cfg.Path, m.vLogName(id))
-
-	fh, err := os.Open(fp)
+func (m *mutcask) Read(key string, w io.Writer) (n int, err error) {
+	ctx, span := m.startOp("Read", key)
+	defer func() { endSpan(span, err) }()
+	hint, _, fh, release, err := m.acquire(ctx, key)
 	if err != nil {
 		return 0, err
 	}
-	defer fh.Close()
+	if hint.Inline {
+		release()
+		return w.Write(hint.Value)
+	}
+	if hint.Chunks != nil {
+		release()
+		return m.readChunks(ctx, hint, w, 0, hint.valueSize())
+	}
+	defer release()
+	if hint.EncKey > 0 {
+		// sealed values are only authenticated as a whole
+		v, err := m.readValue(ctx, fh, hint)
+		if err != nil {
+			return 0, err
+		}
+		return w.Write(v)
+	}
 	vOffset := int64(hint.VOffset) + 4
+	_, ioSpan := startSpan(ctx, "vlog.read", attrOffset.Int64(vOffset), attrSize.Int64(int64(hint.VSize)-4))
+	defer func() { endSpan(ioSpan, err) }()
+	if hint.LSize > 0 {
+		dec, err := decompressReader(io.NewSectionReader(fh, vOffset, int64(hint.VSize)-4))
+		if err != nil {
+			return 0, err
+		}
+		defer dec.Close()
+		copied, err := io.Copy(w, dec)
+		return int(copied), err
+	}
 	if n, err := fh.Seek(vOffset, io.SeekStart); err != nil || n != vOffset {
 		return 0, fmt.Errorf("seek failed %s | %d | %d", err, n, vOffset)
 	}
-	n, err := io.CopyN(w, fh, int64(hint.VSize)-4)
+	copied, err := io.CopyN(w, fh, int64(hint.VSize)-4)
 
-	return int(n), err
+	return int(copied), err
 }
 
-func (m *mutcask) CheckSum(key string) (string, error) {
-	v, err := m.Get(key)
+// ReadRange copies length bytes of the value of key starting at offset to w,
+// a negative length reads to the end of the value
+func (m *mutcask) ReadRange(key string, w io.Writer, offset, length int64) (n int, err error) {
+	ctx, span := m.startOp("ReadRange", key)
+	defer func() { endSpan(span, err) }()
+	hint, _, fh, release, err := m.acquire(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if hint.Inline {
+		release()
+		if length, err = clampRange(hint.valueSize(), offset, length); err != nil {
+			return 0, err
+		}
+		return w.Write(hint.Value[offset : offset+length])
+	}
+	if hint.Chunks != nil {
+		// only the chunks covering the range are read, each under the lock
+		// of its own cask
+		release()
+		if length, err = clampRange(hint.valueSize(), offset, length); err != nil {
+			return 0, err
+		}
+		return m.readChunks(ctx, hint, w, offset, length)
+	}
+	defer release()
+	if length, err = clampRange(hint.valueSize(), offset, length); err != nil {
+		return 0, err
+	}
+	if hint.EncKey > 0 {
+		v, err := m.readValue(ctx, fh, hint)
+		if err != nil {
+			return 0, err
+		}
+		return w.Write(v[offset : offset+length])
+	}
+	if hint.LSize > 0 {
+		return readCompressedRange(ctx, fh, hint, w, offset, length)
+	}
+	vOffset := int64(hint.VOffset) + 4 + offset
+	_, ioSpan := startSpan(ctx, "vlog.read", attrOffset.Int64(vOffset), attrSize.Int64(length))
+	copied, err := io.Copy(w, io.NewSectionReader(fh, vOffset, length))
+	endSpan(ioSpan, err)
+	return int(copied), err
+}
+
+// clampRange checks offset against the size of a value and returns the
+// length left to read from there
+func clampRange(size, offset, length int64) (int64, error) {
+	if offset < 0 || offset > size {
+		return 0, ErrRange
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	return length, nil
+}
+
+// readCompressedRange decompresses the value up to offset+length, only the
+// requested range is written to w
+func readCompressedRange(ctx context.Context, fh *os.File, hint *Hint, w io.Writer, offset, length int64) (n int, err error) {
+	vOffset := int64(hint.VOffset) + 4
+	_, ioSpan := startSpan(ctx, "vlog.read", attrOffset.Int64(vOffset), attrSize.Int64(int64(hint.VSize)-4))
+	defer func() { endSpan(ioSpan, err) }()
+	dec, err := decompressReader(io.NewSectionReader(fh, vOffset, int64(hint.VSize)-4))
+	if err != nil {
+		return 0, err
+	}
+	defer dec.Close()
+	if _, err = io.CopyN(io.Discard, dec, offset); err != nil {
+		return 0, err
+	}
+	copied, err := io.CopyN(w, dec, length)
+	return int(copied), err
+}
+
+func (m *mutcask) CheckSum(key string) (sum string, err error) {
+	ctx, span := m.startOp("CheckSum", key)
+	defer func() { endSpan(span, err) }()
+	if hint, err := m.lookup(ctx, key); err == nil && hint.Chunks != nil {
+		// hashed a chunk at a time rather than reassembled
+		h := sha256.New()
+		if _, err := m.readChunks(ctx, hint, h, 0, hint.valueSize()); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	v, err := m.getValue(ctx, key)
 	if err != nil {
 		return "", err
 	}
-	sum := sha256.Sum256(v)
-	return hex.EncodeToString(sum[:]), nil
+	digest := sha256.Sum256(v)
+	return hex.EncodeToString(digest[:]), nil
 }
 
-func (m *mutcask) Size(key string) (int, error) {
+func (m *mutcask) Size(key string) (size int, err error) {
+	ctx, span := m.startOp("Size", key)
+	defer func() { endSpan(span, err) }()
 	// id := m.fileID(key)
 	// cask, has := m.caskMap.Get(id)
 	// if !has {
 	// 	return -1, ErrNotFound
 	// }
-	hint, err := get_hint(m.keys, key)
+	hint, err := m.lookup(ctx, key)
 	if err != nil {
 		return -1, ErrNotFound
 	}
-	return int(hint.VSize - 4), nil
+	return int(hint.valueSize()), nil
 }
 
 func (m *mutcask) Close() error {
-	m.caskMap.CloseAll()
 	m.close()
 	return nil
 }
 func (m *mutcask) AllKeysChan(ctx context.Context) (chan string, error) {
-	iter := m.keys.NewIterator(nil, nil)
+	db, release := m.index()
+	iter := db.NewIterator(userRange(), nil)
 	out := make(chan string, 1)
 	go func(iter iterator.Iterator, oc chan string) {
+		defer release()
 		defer iter.Release()
 		defer close(out)
 		for {
@@ -263,7 +649,14 @@ func (m *mutcask) AllKeysChan(ctx context.Context) (chan string, error) {
 			if !iter.Next() {
 				return
 			}
-			out <- string(iter.Key())
+			if !m.liveEntry(iter.Value(), time.Now()) {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- string(iter.Key()):
+			}
 		}
 		// Todo: log if has iter.Error()
 	}(iter, out)
@@ -292,8 +685,84 @@ func (m *mutcask) AllKeysChan(ctx context.Context) (chan string, error) {
 }
 
 func (m *mutcask) fileID(key string) uint32 {
-	crc := crc32.ChecksumIEEE([]byte(key))
-	return crc % m.cfg.CaskNum
+	return caskID(key, m.cfg.CaskNum)
+}
+
+func caskID(key string, caskNum uint32) uint32 {
+	// versions stay in the cask of their key
+	crc := crc32.ChecksumIEEE([]byte(entryKey(key)))
+	return crc % caskNum
+}
+
+// acquire resolves the hint of key, read-locks the cask storing its value,
+// which for shared values may not be the cask of the key, and opens its vlog
+// unless the value is kept elsewhere. The returned func closes the vlog and
+// releases the lock. It fails with ErrNotFound when the key has no value.
+func (m *mutcask) acquire(ctx context.Context, key string) (*Hint, uint32, *os.File, func(), error) {
+	id := m.fileID(key)
+	stale := 0
+	for {
+		release := m.rlockCask(id)
+		hint, err := m.lookup(ctx, key)
+		if err != nil {
+			release()
+			return nil, 0, nil, nil, ErrNotFound
+		}
+		if hint.caskID(m.fileID(key)) != id {
+			// the value is elsewhere, or moved there before the lock was
+			// taken
+			release()
+			id = hint.caskID(m.fileID(key))
+			continue
+		}
+		if hint.Inline || hint.Chunks != nil {
+			return hint, id, nil, release, nil
+		}
+		fh, err := m.openVLog(hint.vlogs, id)
+		if err == errStaleVLog && stale < roOpenRetries {
+			// the writer compacted the vlog since the index was loaded
+			release()
+			stale++
+			if err = m.Refresh(); err != nil {
+				return nil, 0, nil, nil, err
+			}
+			continue
+		}
+		if err != nil {
+			release()
+			return nil, 0, nil, nil, err
+		}
+		return hint, id, fh, func() {
+			fh.Close()
+			release()
+		}, nil
+	}
+}
+
+// rlockCask keeps compaction from swapping the vlog of a cask while a hint
+// is resolved against it, the returned func releases the lock
+func (m *mutcask) rlockCask(id uint32) func() {
+	cask, has := m.caskMap.Get(id)
+	if !has {
+		return func() {}
+	}
+	cask.swap.RLock()
+	return cask.swap.RUnlock
+}
+
+// readRecord reads the encoded record the hint points to, the returned buffer
+// should be put back to vBuf by the caller
+func readRecord(fh *os.File, hint *Hint) (*vbuffer, error) {
+	buf := vBuf.Get().(*vbuffer)
+	buf.size(int(hint.VSize))
+	if _, err := fh.ReadAt(*buf, int64(hint.VOffset)); err != nil {
+		vBuf.Put(buf)
+		if err == io.EOF {
+			return nil, ErrReadHintBeyondRange
+		}
+		return nil, err
+	}
+	return buf, nil
 }
 
 type createCaskRequst struct {
@@ -2,6 +2,7 @@ package mutcask
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"sync"
@@ -169,3 +170,67 @@ type kvt struct {
 	Key   string
 	Value []byte
 }
+
+func TestCompact(t *testing.T) {
+	var kvdata = []kvt{
+		{"Qmc35RPEYrW3Mj1mki6thkAjx6a1ZFkU3UYxAyFhMmngr2", []byte("124567")},
+		{"QmTwNzgUFg2kCZ47AmsKUDHwnfAhcGj6TB4mNZcott9zWc", []byte("224567")},
+		{"QmYgPV5bT37u56qePZUqLQ15JhnopaSmVx8ao39RUCoZEj", []byte("324567")},
+		{"QmfVM2KjyzYYRn3geYnqv6EWqSwRZAPpdFcgEhc61ycJRp", []byte("424567")},
+	}
+	dir := tmpdirpath(t)
+	mutc, err := NewMutcask(PathConf(dir), CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range kvdata {
+		if err := mutc.Put(item.Key, []byte("stale value")); err != nil {
+			t.Fatal(err)
+		}
+		if err := mutc.Put(item.Key, item.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mutc.Delete(kvdata[0].Key); err != nil {
+		t.Fatal(err)
+	}
+	if err := mutc.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	st, err := mutc.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Keys != 3 || st.VLogBytes != st.LiveBytes || st.LiveBytes != 3*10 {
+		t.Fatalf("unexpected stats after compaction %#v", st)
+	}
+	for _, item := range kvdata[1:] {
+		v, err := mutc.Get(item.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v, item.Value) {
+			t.Fatalf("%s should equal to %s", v, item.Value)
+		}
+	}
+	// writes keep appending to the rewritten vlog
+	if err := mutc.Put(kvdata[0].Key, kvdata[0].Value); err != nil {
+		t.Fatal(err)
+	}
+	mutc.Close()
+
+	mutc, err = NewMutcask(PathConf(dir), CaskNumConf(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mutc.Close()
+	for _, item := range kvdata {
+		v, err := mutc.Get(item.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v, item.Value) {
+			t.Fatalf("%s should equal to %s", v, item.Value)
+		}
+	}
+}
@@ -1,5 +1,13 @@
 package mutcask
 
+import (
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
 type Config struct {
 	Path            string
 	CaskNum         uint32
@@ -7,13 +15,58 @@ type Config struct {
 	InitBuf         int
 	Migrate         bool
 	MaxLogFileSize  int
+	// bytes per second the scrubber may read, 0 means unlimited
+	ScrubRate int
+	// pause between two background scrub passes, 0 disables the scrubber
+	ScrubInterval time.Duration
+	// called when the scrubber finds a corrupt value
+	OnCorrupt func(key string, err error)
+	// open without taking the repo lock, writes are rejected
+	ReadOnly bool
+	// how often a read-only repo reloads the index to see new writes, 0
+	// leaves it to explicit Refresh calls
+	RefreshInterval time.Duration
+	// receives diagnostics, nothing is logged by default
+	Logger *slog.Logger
+	// traces KVDB calls, nothing is traced by default
+	TracerProvider trace.TracerProvider
+	// codec of values written from now on, existing records are read
+	// whatever they were written with
+	Compression Compression
+	// seals values written from now on when set
+	KeyProvider KeyProvider
+	Cipher      Cipher
+	// stores values written from now on once per content
+	Dedup bool
+	// values larger than this are split into chunks of this size, 0 keeps
+	// them whole
+	ChunkSize int
+	// values up to this size are kept in the index rather than the vlog,
+	// unless they are to be sealed
+	InlineSize int
+	// how often keys whose TTL passed are deleted, 0 leaves it to explicit
+	// Reap calls
+	ReapInterval time.Duration
+	// values replaced or deleted are kept as versions while they are among
+	// the last Versions ones of their key or younger than VersionAge,
+	// compaction drops the others. Both 0 keeps no versions.
+	Versions   int
+	VersionAge time.Duration
+}
+
+func (cfg *Config) versioned() bool {
+	return cfg.Versions > 0 || cfg.VersionAge > 0
 }
 
 func defaultConfig() *Config {
 	return &Config{
 		CaskNum:         256,
 		HintBootReadNum: 1000,
+		ReapInterval:    time.Minute,
 		MaxLogFileSize:  1 << 20,
+		RefreshInterval: time.Second,
+		Logger:          nopLogger,
+		TracerProvider:  noop.NewTracerProvider(),
 	}
 }
 
@@ -48,3 +101,101 @@ func MigrateConf() Option {
 		cfg.Migrate = true
 	}
 }
+
+func ScrubConf(rate int, interval time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ScrubRate = rate
+		cfg.ScrubInterval = interval
+	}
+}
+
+func OnCorruptConf(fn func(key string, err error)) Option {
+	return func(cfg *Config) {
+		cfg.OnCorrupt = fn
+	}
+}
+
+func ReadOnlyConf() Option {
+	return func(cfg *Config) {
+		cfg.ReadOnly = true
+	}
+}
+
+func RefreshIntervalConf(interval time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.RefreshInterval = interval
+	}
+}
+
+func LoggerConf(l *slog.Logger) Option {
+	return func(cfg *Config) {
+		if l == nil {
+			l = nopLogger
+		}
+		cfg.Logger = l
+	}
+}
+
+func TracerProviderConf(tp trace.TracerProvider) Option {
+	return func(cfg *Config) {
+		if tp == nil {
+			tp = noop.NewTracerProvider()
+		}
+		cfg.TracerProvider = tp
+	}
+}
+
+func CompressionConf(c Compression) Option {
+	return func(cfg *Config) {
+		cfg.Compression = c
+	}
+}
+
+// EncryptionConf seals values with the current key of kp, records sealed
+// before keep their key and cipher
+func EncryptionConf(kp KeyProvider, c Cipher) Option {
+	return func(cfg *Config) {
+		cfg.KeyProvider = kp
+		cfg.Cipher = c
+	}
+}
+
+// DedupConf stores identical values once, keys putting them share the record
+func DedupConf() Option {
+	return func(cfg *Config) {
+		cfg.Dedup = true
+	}
+}
+
+// ChunkConf splits values larger than size into chunks of size, which are
+// spread over the casks and read back one at a time
+func ChunkConf(size int) Option {
+	return func(cfg *Config) {
+		cfg.ChunkSize = size
+	}
+}
+
+// InlineConf keeps values up to size in their index entry, so reading them
+// does not touch the vlog
+func InlineConf(size int) Option {
+	return func(cfg *Config) {
+		cfg.InlineSize = size
+	}
+}
+
+// VersioningConf keeps the last keep versions of every key and those
+// replaced less than age ago, either may be 0
+func VersioningConf(keep int, age time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.Versions = keep
+		cfg.VersionAge = age
+	}
+}
+
+// ReapIntervalConf sets how often expired keys are deleted, 0 stops the
+// background reaper
+func ReapIntervalConf(d time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ReapInterval = d
+	}
+}
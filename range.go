@@ -0,0 +1,56 @@
+package mutcask
+
+import (
+	"io"
+	"math"
+)
+
+// rangeReader is implemented by KVDBs which read part of a value without
+// reading all of it
+type rangeReader interface {
+	ReadRange(key string, w io.Writer, offset, length int64) (int, error)
+}
+
+// ReadRange copies length bytes of the value of key starting at offset to w,
+// a negative length reads to the end. KVDBs which can only read whole values
+// have the bytes outside the range dropped.
+func ReadRange(db KVDB, key string, w io.Writer, offset, length int64) (int, error) {
+	if rr, ok := db.(rangeReader); ok {
+		return rr.ReadRange(key, w, offset, length)
+	}
+	if length < 0 {
+		length = math.MaxInt64
+	}
+	rw := &rangeWriter{w: w, skip: offset, left: length}
+	_, err := db.Read(key, rw)
+	return int(length - rw.left), err
+}
+
+// rangeWriter passes on part of what is written to it
+type rangeWriter struct {
+	w    io.Writer
+	skip int64
+	left int64
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if rw.skip > 0 {
+		if int64(len(p)) <= rw.skip {
+			rw.skip -= int64(len(p))
+			return n, nil
+		}
+		p = p[rw.skip:]
+		rw.skip = 0
+	}
+	if int64(len(p)) > rw.left {
+		p = p[:rw.left]
+	}
+	if len(p) > 0 {
+		if _, err := rw.w.Write(p); err != nil {
+			return 0, err
+		}
+		rw.left -= int64(len(p))
+	}
+	return n, nil
+}
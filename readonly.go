@@ -0,0 +1,355 @@
+package mutcask
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"golang.org/x/xerrors"
+)
+
+// attempts to open the index while the writer replaces its manifest
+const roOpenRetries = 3
+
+// errStaleVLog tells that the writer swapped in a compacted vlog since the
+// index of a read-only repo was loaded, its offsets are of no use then
+var errStaleVLog = xerrors.New("mutcask: vlog compacted since the index was loaded")
+
+// roIndex holds the index of a read-only repo. Every refresh opens it anew,
+// the one replaced is closed once the last reader is done with it.
+type roIndex struct {
+	mu  sync.RWMutex
+	cur *indexRef
+}
+
+type indexRef struct {
+	db *leveldb.DB
+	// the vlogs the offsets in db are into, by cask
+	vlogs map[uint32]os.FileInfo
+	refs  sync.WaitGroup
+}
+
+func (ri *roIndex) acquire() (*indexRef, func()) {
+	ri.mu.RLock()
+	defer ri.mu.RUnlock()
+	ref := ri.cur
+	ref.refs.Add(1)
+	return ref, ref.refs.Done
+}
+
+// swap installs db along with its vlogs, passing nil closes the index for
+// good
+func (ri *roIndex) swap(db *leveldb.DB, vlogs map[uint32]os.FileInfo) {
+	ri.mu.Lock()
+	old := ri.cur
+	if db != nil {
+		ri.cur = &indexRef{db: db, vlogs: vlogs}
+	}
+	ri.mu.Unlock()
+	if old == nil {
+		return
+	}
+	if db == nil {
+		old.refs.Wait()
+		old.db.Close()
+		return
+	}
+	go func() {
+		old.refs.Wait()
+		old.db.Close()
+	}()
+}
+
+// index returns the index along with a func to call once done with it
+func (m *mutcask) index() (*leveldb.DB, func()) {
+	db, _, release := m.indexVLogs()
+	return db, release
+}
+
+// indexVLogs is index along with the vlogs a read-only index was loaded
+// with, nil for writers
+func (m *mutcask) indexVLogs() (*leveldb.DB, map[uint32]os.FileInfo, func()) {
+	if m.ro == nil {
+		return m.keys, nil, func() {}
+	}
+	ref, release := m.ro.acquire()
+	return ref.db, ref.vlogs, release
+}
+
+// getHint resolves the hint of key, in a read-only repo along with the vlogs
+// the index was loaded with
+func (m *mutcask) getHint(key string) (*Hint, error) {
+	db, vlogs, release := m.indexVLogs()
+	defer release()
+	hint, err := get_hint(db, key)
+	if err == nil {
+		hint.vlogs = vlogs
+	}
+	return hint, err
+}
+
+// openVLog opens the vlog of cask id for reading. Given the vlogs of a
+// read-only index it fails with errStaleVLog unless the vlog is the one the
+// index was loaded with.
+func (m *mutcask) openVLog(vlogs map[uint32]os.FileInfo, id uint32) (*os.File, error) {
+	fh, err := os.Open(m.vLogPath(id))
+	if err != nil || vlogs == nil {
+		return fh, err
+	}
+	finfo, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	if !os.SameFile(finfo, vlogs[id]) {
+		fh.Close()
+		return nil, errStaleVLog
+	}
+	return fh, nil
+}
+
+// lookup resolves the hint of key. A read-only repo may still use tables the
+// writer has compacted away, it retries against a refreshed index then.
+func (m *mutcask) lookup(ctx context.Context, key string) (hint *Hint, err error) {
+	_, span := startSpan(ctx, "index.lookup")
+	defer func() {
+		if err == leveldb.ErrNotFound {
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+	hint, err = m.getHint(key)
+	if err != nil && err != leveldb.ErrNotFound && m.ro != nil {
+		if m.Refresh() == nil {
+			hint, err = m.getHint(key)
+		}
+	}
+	return hint, err
+}
+
+// Refresh reloads the index and the vlog sizes of a read-only repo, so values
+// written since it was opened become visible. It is a no-op for writers.
+func (m *mutcask) Refresh() error {
+	if m.ro == nil {
+		return nil
+	}
+	m.maint.Lock()
+	defer m.maint.Unlock()
+	db, vlogs, err := m.loadIndex()
+	if err != nil {
+		return err
+	}
+	m.ro.swap(db, vlogs)
+	return nil
+}
+
+// loadIndex opens the index of a read-only repo along with the vlogs its
+// offsets are into. The vlogs are looked at first, so one the writer swaps
+// in afterwards is told apart by openVLog. An index caught halfway through
+// a swap is opened again.
+func (m *mutcask) loadIndex() (db *leveldb.DB, vlogs map[uint32]os.FileInfo, err error) {
+	for i := 0; i < roOpenRetries; i++ {
+		if vlogs, err = statVLogs(m.cfg.Path); err != nil {
+			return nil, nil, err
+		}
+		if db, err = openIndexReadOnly(filepath.Join(m.cfg.Path, keys_dir)); err != nil {
+			return nil, nil, err
+		}
+		if err = m.loadCasks(db, vlogs); err == nil {
+			return db, vlogs, nil
+		}
+		db.Close()
+		if err != errStaleVLog {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil, nil, err
+}
+
+func statVLogs(dir string) (map[uint32]os.FileInfo, error) {
+	ids, err := vLogIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	vlogs := make(map[uint32]os.FileInfo, len(ids))
+	for _, id := range ids {
+		if vlogs[id], err = os.Stat(filepath.Join(dir, VLogName(id))); err != nil {
+			return nil, err
+		}
+	}
+	return vlogs, nil
+}
+
+// loadCasks picks up the vlogs of a read-only repo, which are only read by
+// path and never opened for writing
+func (m *mutcask) loadCasks(db *leveldb.DB, vlogs map[uint32]os.FileInfo) error {
+	for id, finfo := range vlogs {
+		// the writer has yet to rename the compacted vlog in place
+		if swapping, err := db.Has(compactMarker(id), nil); err != nil || swapping {
+			if err == nil {
+				err = errStaleVLog
+			}
+			return err
+		}
+		cask, has := m.caskMap.Get(id)
+		if !has {
+			cask = NewCask(id, nil)
+			cask.log = m.cfg.Logger.With("cask", id)
+			cask.path = m.vLogPath(id)
+			m.caskMap.Add(id, cask)
+		}
+		gen, err := loadCaskGen(db, id)
+		if err != nil {
+			return err
+		}
+		cask.gen = gen
+		atomic.StoreUint64(&cask.vLogSize, uint64(finfo.Size()))
+	}
+	return nil
+}
+
+func (m *mutcask) startRefresher() {
+	if m.cfg.RefreshInterval <= 0 {
+		return
+	}
+	m.bg.Add(1)
+	go func() {
+		defer m.bg.Done()
+		for {
+			select {
+			case <-m.closeChan:
+				return
+			case <-time.After(m.cfg.RefreshInterval):
+				if err := m.Refresh(); err != nil {
+					m.cfg.Logger.Warn("refresh index", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// openIndexReadOnly opens the index without the leveldb lock, so it can be
+// read while the writer holds it
+func openIndexReadOnly(dir string) (db *leveldb.DB, err error) {
+	for i := 0; i < roOpenRetries; i++ {
+		db, err = leveldb.Open(&roStorage{dir: dir}, &opt.Options{ReadOnly: true})
+		if !os.IsNotExist(err) {
+			break
+		}
+	}
+	return db, err
+}
+
+// roStorage is a leveldb storage over the index directory of a running
+// writer. It takes no lock and refuses to change anything. File names are
+// the ones storage.OpenFile uses, which FileDesc.String also gives.
+type roStorage struct {
+	dir string
+}
+
+var _ storage.Storage = (*roStorage)(nil)
+
+type roLocker struct{}
+
+func (roLocker) Unlock() {}
+
+func (s *roStorage) Lock() (storage.Locker, error) {
+	return roLocker{}, nil
+}
+
+func (s *roStorage) Log(str string) {}
+
+func (s *roStorage) SetMeta(fd storage.FileDesc) error {
+	return ErrReadOnly
+}
+
+func (s *roStorage) GetMeta() (storage.FileDesc, error) {
+	b, err := os.ReadFile(filepath.Join(s.dir, "CURRENT"))
+	if err != nil {
+		return storage.FileDesc{}, err
+	}
+	fd, ok := parseLevelDBName(strings.TrimSuffix(string(b), "\n"))
+	if !ok || fd.Type != storage.TypeManifest {
+		return storage.FileDesc{}, &storage.ErrCorrupted{
+			Err: fmt.Errorf("invalid CURRENT file %q", b),
+		}
+	}
+	if _, err = os.Stat(filepath.Join(s.dir, fd.String())); err != nil {
+		return storage.FileDesc{}, err
+	}
+	return fd, nil
+}
+
+func (s *roStorage) List(ft storage.FileType) ([]storage.FileDesc, error) {
+	dirents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var fds []storage.FileDesc
+	for _, ent := range dirents {
+		if fd, ok := parseLevelDBName(ent.Name()); ok && fd.Type&ft != 0 {
+			fds = append(fds, fd)
+		}
+	}
+	return fds, nil
+}
+
+func (s *roStorage) Open(fd storage.FileDesc) (storage.Reader, error) {
+	f, err := os.Open(filepath.Join(s.dir, fd.String()))
+	if os.IsNotExist(err) && fd.Type == storage.TypeTable {
+		// tables written by old leveldb versions
+		f, err = os.Open(filepath.Join(s.dir, fmt.Sprintf("%06d.sst", fd.Num)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *roStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *roStorage) Remove(fd storage.FileDesc) error {
+	return ErrReadOnly
+}
+
+func (s *roStorage) Rename(oldfd, newfd storage.FileDesc) error {
+	return ErrReadOnly
+}
+
+func (s *roStorage) Close() error {
+	return nil
+}
+
+func parseLevelDBName(name string) (fd storage.FileDesc, ok bool) {
+	var tail string
+	if _, err := fmt.Sscanf(name, "%d.%s", &fd.Num, &tail); err == nil {
+		switch tail {
+		case "log":
+			fd.Type = storage.TypeJournal
+		case "ldb", "sst":
+			fd.Type = storage.TypeTable
+		case "tmp":
+			fd.Type = storage.TypeTemp
+		default:
+			return fd, false
+		}
+		return fd, true
+	}
+	if n, _ := fmt.Sscanf(name, "MANIFEST-%d%s", &fd.Num, &tail); n == 1 {
+		fd.Type = storage.TypeManifest
+		return fd, true
+	}
+	return fd, false
+}
@@ -0,0 +1,146 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	dir := tmpdirpath(t)
+	mutc, err := NewMutcask(PathConf(dir), CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mutc.Close()
+	for i := 0; i < 50; i++ {
+		if err := mutc.Put(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("value-%03d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ro, err := NewMutcask(PathConf(dir), CaskNumConf(4), ReadOnlyConf(), RefreshIntervalConf(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	v, err := ro.Get("key-007")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("value-007")) {
+		t.Fatalf("unexpected value %q", v)
+	}
+	if err := ro.Put("key-100", []byte("x")); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if err := ro.Delete("key-007"); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+
+	if err := mutc.Put("key-100", []byte("value-100")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mutc.Delete("key-007"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ro.Get("key-100"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound before refresh, got %v", err)
+	}
+	if err := ro.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	v, err = ro.Get("key-100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("value-100")) {
+		t.Fatalf("unexpected value %q", v)
+	}
+	if _, err := ro.Get("key-007"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	keys, err := ro.ListKeys("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 50 {
+		t.Fatalf("expected 50 keys, got %d", len(keys))
+	}
+}
+
+func TestReadOnlyRefreshInterval(t *testing.T) {
+	dir := tmpdirpath(t)
+	mutc, err := NewMutcask(PathConf(dir), CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mutc.Close()
+	if err := mutc.Put("key-000", []byte("value-000")); err != nil {
+		t.Fatal(err)
+	}
+	ro, err := NewMutcask(PathConf(dir), CaskNumConf(4), ReadOnlyConf(), RefreshIntervalConf(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if err := mutc.Put("key-001", []byte("value-001")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := ro.Get("key-001")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("write not picked up: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReadOnlyCompacted(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	mutc, err := NewMutcask(PathConf(dir), CaskNumConf(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mutc.Close()
+	// records of the same size, compaction moves one where another was
+	for i := 0; i < 20; i++ {
+		if err := mutc.Put(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("value-%03d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ro, err := NewMutcask(PathConf(dir), CaskNumConf(1), ReadOnlyConf(), RefreshIntervalConf(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	for i := 0; i < 10; i++ {
+		if err := mutc.Delete(fmt.Sprintf("key-%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mutc.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reader still holds the index from before the compaction
+	var buf bytes.Buffer
+	if _, err := ro.ReadRange("key-000", &buf, 0, -1); err != ErrNotFound {
+		t.Fatalf("read range of compacted key %q: %v", buf.Bytes(), err)
+	}
+	for i := 0; i < 20; i++ {
+		key, want := fmt.Sprintf("key-%03d", i), fmt.Sprintf("value-%03d", i)
+		v, err := ro.Get(key)
+		if i < 10 && err != ErrNotFound || i >= 10 && (err != nil || string(v) != want) {
+			t.Fatalf("get %s %q: %v", key, v, err)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+// Package s3gw serves a mutcask KVDB through a minimal S3 compatible API,
+// addressed path style: buckets, PutObject, GetObject with Range,
+// HeadObject, DeleteObject, ListObjectsV2 and multipart uploads.
+//
+// Everything is kept in the KVDB, which should be used by the gateway only:
+//
+//	b/<bucket>            bucket record
+//	o/<bucket>/<key>      object record, listing the chunks holding the data
+//	d/<id>                data chunk, a single upload or one part
+//	u/<upload id>         multipart upload
+//	p/<upload id>/<part>  uploaded part
+//
+// ETags are the sha256 of the data, for multipart objects the sha256 of the
+// part digests followed by the part count.
+package s3gw
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filedag-project/mutcask"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// largest object or part a request may upload unless configured otherwise,
+// uploads are buffered in memory
+const DefaultMaxObjectSize = 1 << 30
+
+const (
+	bucketPrefix = "b/"
+	objectPrefix = "o/"
+	chunkPrefix  = "d/"
+	uploadPrefix = "u/"
+	partPrefix   = "p/"
+)
+
+var bucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+type lister interface {
+	ListKeys(prefix, start string, limit int) ([]string, error)
+}
+
+type Gateway struct {
+	db mutcask.KVDB
+	// serializes swapping records which own chunks
+	mu sync.Mutex
+	// requests have to be signed with this key pair, without an access key
+	// every request is let in
+	AccessKey string
+	SecretKey string
+	// region signatures are scoped to
+	Region        string
+	MaxObjectSize int64
+}
+
+var _ http.Handler = (*Gateway)(nil)
+
+func New(db mutcask.KVDB) *Gateway {
+	return &Gateway{
+		db:            db,
+		Region:        "us-east-1",
+		MaxObjectSize: DefaultMaxObjectSize,
+	}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := g.authenticate(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	switch {
+	case bucket == "":
+		if r.Method != http.MethodGet {
+			writeError(w, r, errMethodNotAllowed)
+			return
+		}
+		g.listBuckets(w, r)
+	case key == "":
+		switch r.Method {
+		case http.MethodPut:
+			g.createBucket(w, r, bucket)
+		case http.MethodHead:
+			g.headBucket(w, r, bucket)
+		case http.MethodDelete:
+			g.deleteBucket(w, r, bucket)
+		case http.MethodGet:
+			if q.Get("list-type") != "2" {
+				writeError(w, r, errNotImplemented)
+				return
+			}
+			g.listObjects(w, r, bucket)
+		default:
+			writeError(w, r, errMethodNotAllowed)
+		}
+	default:
+		switch r.Method {
+		case http.MethodPut:
+			if r.Header.Get("X-Amz-Copy-Source") != "" {
+				writeError(w, r, errNotImplemented)
+			} else if q.Has("uploadId") {
+				g.uploadPart(w, r, bucket, key)
+			} else {
+				g.putObject(w, r, bucket, key)
+			}
+		case http.MethodGet, http.MethodHead:
+			g.getObject(w, r, bucket, key)
+		case http.MethodDelete:
+			if q.Has("uploadId") {
+				g.abortUpload(w, r, bucket, key)
+			} else {
+				g.deleteObject(w, r, bucket, key)
+			}
+		case http.MethodPost:
+			if q.Has("uploads") {
+				g.createUpload(w, r, bucket, key)
+			} else if q.Has("uploadId") {
+				g.completeUpload(w, r, bucket, key)
+			} else {
+				writeError(w, r, errNotImplemented)
+			}
+		default:
+			writeError(w, r, errMethodNotAllowed)
+		}
+	}
+}
+
+type bucketRecord struct {
+	Created time.Time `cbor:"c"`
+}
+
+func (g *Gateway) createBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !bucketName.MatchString(bucket) {
+		writeError(w, r, errInvalidBucketName)
+		return
+	}
+	if _, err := g.db.Size(bucketPrefix + bucket); err == nil {
+		writeError(w, r, errBucketExists)
+		return
+	}
+	if err := g.putRecord(bucketPrefix+bucket, &bucketRecord{Created: time.Now().UTC()}); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) headBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := g.checkBucket(bucket); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) deleteBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := g.checkBucket(bucket); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	keys, err := g.listKeys(r.Context(), objectPrefix+bucket+"/", "", 1)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(keys) > 0 {
+		writeError(w, r, errBucketNotEmpty)
+		return
+	}
+	if err := g.db.Delete(bucketPrefix + bucket); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listBucketsResult struct {
+	XMLName xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   owner        `xml:"Owner"`
+	Buckets []bucketInfo `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	keys, err := g.listKeys(r.Context(), bucketPrefix, "", 0)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res := &listBucketsResult{Owner: owner{ID: "mutcask", DisplayName: "mutcask"}}
+	for _, key := range keys {
+		rec := &bucketRecord{}
+		if err := g.getRecord(key, rec); err != nil {
+			continue
+		}
+		res.Buckets = append(res.Buckets, bucketInfo{
+			Name:         strings.TrimPrefix(key, bucketPrefix),
+			CreationDate: rec.Created.Format(time.RFC3339),
+		})
+	}
+	writeXML(w, http.StatusOK, res)
+}
+
+func (g *Gateway) checkBucket(bucket string) error {
+	if _, err := g.db.Size(bucketPrefix + bucket); err != nil {
+		if err == mutcask.ErrNotFound {
+			return errNoSuchBucket
+		}
+		return err
+	}
+	return nil
+}
+
+func (g *Gateway) putRecord(key string, v interface{}) error {
+	d, err := cbor.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return g.db.Put(key, d)
+}
+
+func (g *Gateway) getRecord(key string, v interface{}) error {
+	d, err := g.db.Get(key)
+	if err != nil {
+		return err
+	}
+	return cbor.Unmarshal(d, v)
+}
+
+// listKeys lists up to limit keys with prefix sorting after start, limit <= 0
+// lists all of them
+func (g *Gateway) listKeys(ctx context.Context, prefix, start string, limit int) ([]string, error) {
+	if l, ok := g.db.(lister); ok {
+		return l.ListKeys(prefix, start, limit)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	kc, err := g.db.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range kc {
+		if strings.HasPrefix(key, prefix) && key > start {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, ctx.Err()
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
+
+// s3Error is an error response of the S3 API
+type s3Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errAccessDenied          = &s3Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errInvalidAccessKey      = &s3Error{http.StatusForbidden, "InvalidAccessKeyId", "The access key you provided does not exist"}
+	errSignatureMismatch     = &s3Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match"}
+	errAuthHeaderMalformed   = &s3Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed"}
+	errMissingSecurityHeader = &s3Error{http.StatusBadRequest, "MissingSecurityHeader", "Your request is missing a required header"}
+	errRequestExpired        = &s3Error{http.StatusForbidden, "RequestTimeTooSkewed", "The request time is too far from the server time"}
+	errNoSuchBucket          = &s3Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	errNoSuchKey             = &s3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	errNoSuchUpload          = &s3Error{http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist"}
+	errBucketExists          = &s3Error{http.StatusConflict, "BucketAlreadyOwnedByYou", "The bucket already exists"}
+	errBucketNotEmpty        = &s3Error{http.StatusConflict, "BucketNotEmpty", "The bucket is not empty"}
+	errInvalidBucketName     = &s3Error{http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid"}
+	errKeyTooLong            = &s3Error{http.StatusBadRequest, "KeyTooLongError", "The key is too long"}
+	errEntityTooSmall        = &s3Error{http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size"}
+	errEntityTooLarge        = &s3Error{http.StatusBadRequest, "EntityTooLarge", "The upload exceeds the maximum allowed object size"}
+	errBadDigest             = &s3Error{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received"}
+	errSha256Mismatch        = &s3Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided x-amz-content-sha256 does not match what was computed"}
+	errInvalidRange          = &s3Error{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable"}
+	errInvalidPart           = &s3Error{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found"}
+	errInvalidPartOrder      = &s3Error{http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order"}
+	errMalformedXML          = &s3Error{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed"}
+	errInvalidArgument       = &s3Error{http.StatusBadRequest, "InvalidArgument", "Invalid argument"}
+	errReadOnly              = &s3Error{http.StatusForbidden, "AccessDenied", "The repo is read-only"}
+	errNotImplemented        = &s3Error{http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented"}
+	errMethodNotAllowed      = &s3Error{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource"}
+	errInternal              = &s3Error{http.StatusInternalServerError, "InternalError", "We encountered an internal error"}
+	errMissingContentSize    = &s3Error{http.StatusLengthRequired, "MissingContentLength", "You must provide the Content-Length HTTP header"}
+)
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var se *s3Error
+	switch {
+	case errors.As(err, &se):
+	case errors.Is(err, mutcask.ErrReadOnly):
+		se = errReadOnly
+	case errors.Is(err, mutcask.ErrKeySizeTooLong):
+		se = errKeyTooLong
+	default:
+		se = &s3Error{errInternal.Status, errInternal.Code, err.Error()}
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(se.Status)
+		return
+	}
+	writeXML(w, se.Status, &errorResponse{
+		Code:     se.Code,
+		Message:  se.Message,
+		Resource: r.URL.Path,
+	})
+}
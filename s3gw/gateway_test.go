@@ -0,0 +1,255 @@
+package s3gw
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/filedag-project/mutcask"
+)
+
+func newClient(t *testing.T, secret string) *s3.S3 {
+	db, err := mutcask.NewMutcask(mutcask.PathConf(t.TempDir()), mutcask.CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	g := New(db)
+	g.AccessKey, g.SecretKey = "access", "secret"
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("access", secret, ""),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s3.New(sess)
+}
+
+func errCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+func TestObjects(t *testing.T) {
+	client := newClient(t, "secret")
+	bucket := aws.String("test-bucket")
+	if _, err := client.CreateBucket(&s3.CreateBucketInput{Bucket: bucket}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.PutObject(&s3.PutObjectInput{Bucket: aws.String("missing"), Key: aws.String("k"), Body: strings.NewReader("v")}); errCode(err) != "NoSuchBucket" {
+		t.Fatalf("expected NoSuchBucket, got %v", err)
+	}
+
+	value := "0123456789abcdef"
+	put, err := client.PutObject(&s3.PutObjectInput{
+		Bucket:      bucket,
+		Key:         aws.String("dir/some key"),
+		Body:        strings.NewReader(value),
+		ContentType: aws.String("text/plain"),
+		Metadata:    map[string]*string{"Color": aws.String("blue")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get, err := client.GetObject(&s3.GetObjectInput{Bucket: bucket, Key: aws.String("dir/some key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(get.Body)
+	get.Body.Close()
+	if string(body) != value || aws.StringValue(get.ETag) != aws.StringValue(put.ETag) {
+		t.Fatalf("unexpected object %q etag %s", body, aws.StringValue(get.ETag))
+	}
+	if aws.StringValue(get.ContentType) != "text/plain" || aws.StringValue(get.Metadata["Color"]) != "blue" {
+		t.Fatalf("unexpected content type %s metadata %v", aws.StringValue(get.ContentType), get.Metadata)
+	}
+
+	get, err = client.GetObject(&s3.GetObjectInput{Bucket: bucket, Key: aws.String("dir/some key"), Range: aws.String("bytes=2-5")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(get.Body)
+	get.Body.Close()
+	if string(body) != "2345" || aws.StringValue(get.ContentRange) != "bytes 2-5/16" {
+		t.Fatalf("unexpected range %q %s", body, aws.StringValue(get.ContentRange))
+	}
+	if _, err := client.GetObject(&s3.GetObjectInput{Bucket: bucket, Key: aws.String("dir/some key"), Range: aws.String("bytes=16-")}); errCode(err) != "InvalidRange" {
+		t.Fatalf("expected InvalidRange, got %v", err)
+	}
+
+	head, err := client.HeadObject(&s3.HeadObjectInput{Bucket: bucket, Key: aws.String("dir/some key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.Int64Value(head.ContentLength) != int64(len(value)) {
+		t.Fatalf("unexpected length %d", aws.Int64Value(head.ContentLength))
+	}
+
+	// a body not matching its Content-MD5 is refused and not stored
+	if _, err := client.PutObject(&s3.PutObjectInput{
+		Bucket:     bucket,
+		Key:        aws.String("bad digest"),
+		Body:       strings.NewReader(value),
+		ContentMD5: aws.String("AAAAAAAAAAAAAAAAAAAAAA=="),
+	}); errCode(err) != "BadDigest" {
+		t.Fatalf("expected BadDigest, got %v", err)
+	}
+	if _, err := client.HeadObject(&s3.HeadObjectInput{Bucket: bucket, Key: aws.String("bad digest")}); errCode(err) != "NotFound" {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+
+	if _, err := client.DeleteBucket(&s3.DeleteBucketInput{Bucket: bucket}); errCode(err) != "BucketNotEmpty" {
+		t.Fatalf("expected BucketNotEmpty, got %v", err)
+	}
+	if _, err := client.DeleteObject(&s3.DeleteObjectInput{Bucket: bucket, Key: aws.String("dir/some key")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.HeadObject(&s3.HeadObjectInput{Bucket: bucket, Key: aws.String("dir/some key")}); errCode(err) != "NotFound" {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+	if _, err := client.DeleteBucket(&s3.DeleteBucketInput{Bucket: bucket}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListObjects(t *testing.T) {
+	client := newClient(t, "secret")
+	bucket := aws.String("list")
+	if _, err := client.CreateBucket(&s3.CreateBucketInput{Bucket: bucket}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a/1", "a/2", "b", "c/1", "c/2", "c/3", "d"} {
+		if _, err := client.PutObject(&s3.PutObjectInput{Bucket: bucket, Key: aws.String(key), Body: strings.NewReader(key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var listed []string
+	err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: bucket, MaxKeys: aws.Int64(2)}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			listed = append(listed, aws.StringValue(o.Key))
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(listed, ",") != "a/1,a/2,b,c/1,c/2,c/3,d" {
+		t.Fatalf("unexpected listing %v", listed)
+	}
+
+	listed = nil
+	pages := 0
+	err = client.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: bucket, Delimiter: aws.String("/"), MaxKeys: aws.Int64(1)}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		pages++
+		for _, p := range page.CommonPrefixes {
+			listed = append(listed, aws.StringValue(p.Prefix))
+		}
+		for _, o := range page.Contents {
+			listed = append(listed, aws.StringValue(o.Key))
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(listed, ",") != "a/,b,c/,d" || pages != 4 {
+		t.Fatalf("unexpected listing %v in %d pages", listed, pages)
+	}
+
+	out, err := client.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: bucket, Prefix: aws.String("c/"), StartAfter: aws.String("c/1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Contents) != 2 || aws.StringValue(out.Contents[0].Key) != "c/2" || aws.BoolValue(out.IsTruncated) {
+		t.Fatalf("unexpected listing %v", out)
+	}
+
+	buckets, err := client.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets.Buckets) != 1 || aws.StringValue(buckets.Buckets[0].Name) != "list" {
+		t.Fatalf("unexpected buckets %v", buckets.Buckets)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	client := newClient(t, "secret")
+	bucket := aws.String("multipart")
+	if _, err := client.CreateBucket(&s3.CreateBucketInput{Bucket: bucket}); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 12<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	uploader := s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		u.PartSize = 5 << 20
+	})
+	res, err := uploader.Upload(&s3manager.UploadInput{Bucket: bucket, Key: aws.String("big"), Body: bytes.NewReader(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(aws.StringValue(res.ETag), `-3"`) {
+		t.Fatalf("unexpected multipart etag %s", aws.StringValue(res.ETag))
+	}
+
+	get, err := client.GetObject(&s3.GetObjectInput{Bucket: bucket, Key: aws.String("big"), Range: aws.String(fmt.Sprintf("bytes=%d-%d", 5<<20-10, 5<<20+9))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(get.Body)
+	get.Body.Close()
+	if !bytes.Equal(body, data[5<<20-10:5<<20+10]) {
+		t.Fatal("range across parts does not match")
+	}
+	get, err = client.GetObject(&s3.GetObjectInput{Bucket: bucket, Key: aws.String("big")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(get.Body)
+	get.Body.Close()
+	if !bytes.Equal(body, data) {
+		t.Fatal("multipart object does not match")
+	}
+
+	up, err := client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: bucket, Key: aws.String("aborted")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.UploadPart(&s3.UploadPartInput{Bucket: bucket, Key: aws.String("aborted"), UploadId: up.UploadId, PartNumber: aws.Int64(1), Body: strings.NewReader("part")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: bucket, Key: aws.String("aborted"), UploadId: up.UploadId}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.UploadPart(&s3.UploadPartInput{Bucket: bucket, Key: aws.String("aborted"), UploadId: up.UploadId, PartNumber: aws.Int64(2), Body: strings.NewReader("part")}); errCode(err) != "NoSuchUpload" {
+		t.Fatalf("expected NoSuchUpload, got %v", err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	client := newClient(t, "wrong")
+	if _, err := client.ListBuckets(&s3.ListBucketsInput{}); errCode(err) != "SignatureDoesNotMatch" {
+		t.Fatalf("expected SignatureDoesNotMatch, got %v", err)
+	}
+}
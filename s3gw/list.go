@@ -0,0 +1,145 @@
+package s3gw
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keys listed per page unless the request asks for fewer
+const defaultMaxKeys = 1000
+
+// sorts after every key sharing a prefix, keys being utf-8
+const skipPrefix = "\xff"
+
+type listObjectsResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []objectInfo   `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type objectInfo struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	if err := g.checkBucket(bucket); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	res := &listObjectsResult{
+		Name:              bucket,
+		Prefix:            q.Get("prefix"),
+		Delimiter:         q.Get("delimiter"),
+		StartAfter:        q.Get("start-after"),
+		ContinuationToken: q.Get("continuation-token"),
+		EncodingType:      q.Get("encoding-type"),
+		MaxKeys:           defaultMaxKeys,
+	}
+	if mk := q.Get("max-keys"); mk != "" {
+		n, err := strconv.Atoi(mk)
+		if err != nil || n < 0 {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+		if n < res.MaxKeys {
+			res.MaxKeys = n
+		}
+	}
+	// the token is where the last page stopped, past start-after
+	marker := res.StartAfter
+	if res.ContinuationToken != "" {
+		b, err := base64.RawURLEncoding.DecodeString(res.ContinuationToken)
+		if err != nil {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+		marker = string(b)
+	}
+
+	base := objectPrefix + bucket + "/"
+	prefix := base + res.Prefix
+	for res.KeyCount < res.MaxKeys {
+		keys, err := g.listKeys(r.Context(), prefix, base+marker, res.MaxKeys-res.KeyCount)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if len(keys) == 0 {
+			break
+		}
+		for _, okey := range keys {
+			key := strings.TrimPrefix(okey, base)
+			if res.Delimiter != "" {
+				if i := strings.Index(key[len(res.Prefix):], res.Delimiter); i >= 0 {
+					cp := key[:len(res.Prefix)+i+len(res.Delimiter)]
+					res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: encodeKey(cp, res.EncodingType)})
+					res.KeyCount++
+					// the other keys of the common prefix are left out
+					marker = cp + skipPrefix
+					break
+				}
+			}
+			rec, err := g.getObjectRecord(okey)
+			if err == errNoSuchKey {
+				// deleted while listing
+				marker = key
+				continue
+			} else if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			res.Contents = append(res.Contents, objectInfo{
+				Key:          encodeKey(key, res.EncodingType),
+				LastModified: rec.Modified.Format(time.RFC3339),
+				ETag:         `"` + rec.ETag + `"`,
+				Size:         rec.Size,
+				StorageClass: "STANDARD",
+			})
+			res.KeyCount++
+			marker = key
+		}
+	}
+	if res.KeyCount > 0 && res.KeyCount == res.MaxKeys {
+		more, err := g.listKeys(r.Context(), prefix, base+marker, 1)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if len(more) > 0 {
+			res.IsTruncated = true
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(marker))
+		}
+	}
+	writeXML(w, http.StatusOK, res)
+}
+
+func encodeKey(key, encoding string) string {
+	if encoding == "url" {
+		return url.QueryEscape(key)
+	}
+	return key
+}
@@ -0,0 +1,520 @@
+package s3gw
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filedag-project/mutcask"
+	"github.com/filedag-project/mutcask/httpapi"
+)
+
+const metaHeaderPrefix = "X-Amz-Meta-"
+
+type chunkRef struct {
+	Key  string `cbor:"k"`
+	Size int64  `cbor:"s"`
+}
+
+type objectRecord struct {
+	Size        int64             `cbor:"s"`
+	ETag        string            `cbor:"e"`
+	ContentType string            `cbor:"t,omitempty"`
+	Modified    time.Time         `cbor:"m"`
+	Meta        map[string]string `cbor:"x,omitempty"`
+	Chunks      []chunkRef        `cbor:"c"`
+}
+
+func objectKey(bucket, key string) (string, error) {
+	okey := objectPrefix + bucket + "/" + key
+	if len(okey) > mutcask.MaxKeySize {
+		return "", errKeyTooLong
+	}
+	return okey, nil
+}
+
+// lookupObject checks the bucket and returns the record key of the object
+func (g *Gateway) lookupObject(bucket, key string) (string, error) {
+	if err := g.checkBucket(bucket); err != nil {
+		return "", err
+	}
+	return objectKey(bucket, key)
+}
+
+func (g *Gateway) getObjectRecord(okey string) (*objectRecord, error) {
+	rec := &objectRecord{}
+	if err := g.getRecord(okey, rec); err != nil {
+		if err == mutcask.ErrNotFound {
+			return nil, errNoSuchKey
+		}
+		return nil, err
+	}
+	return rec, nil
+}
+
+// readBody stores the request body as a chunk, checking it against the
+// digests sent along, and returns the chunk with the sha256 of its data
+func (g *Gateway) readBody(r *http.Request) (chunkRef, []byte, error) {
+	if r.ContentLength < 0 {
+		return chunkRef{}, nil, errMissingContentSize
+	}
+	if r.ContentLength > g.MaxObjectSize {
+		return chunkRef{}, nil, errEntityTooLarge
+	}
+	contentSha := r.Header.Get("X-Amz-Content-Sha256")
+	if strings.HasPrefix(contentSha, "STREAMING-") {
+		return chunkRef{}, nil, errNotImplemented
+	}
+	if len(contentSha) != sha256.Size*2 {
+		// UNSIGNED-PAYLOAD
+		contentSha = ""
+	}
+	body := &bodyReader{
+		sha:     sha256.New(),
+		md5:     md5.New(),
+		max:     g.MaxObjectSize,
+		wantSha: strings.ToLower(contentSha),
+		wantMD5: r.Header.Get("Content-MD5"),
+	}
+	body.r = io.TeeReader(io.LimitReader(r.Body, g.MaxObjectSize+1), io.MultiWriter(body.sha, body.md5))
+	chunk := chunkRef{Key: chunkPrefix + newID()}
+	err := mutcask.PutReader(g.db, chunk.Key, body)
+	if body.err != nil {
+		return chunkRef{}, nil, body.err
+	}
+	if err != nil {
+		return chunkRef{}, nil, err
+	}
+	chunk.Size = body.n
+	return chunk, body.sha.Sum(nil), nil
+}
+
+// bodyReader passes on a request body and fails the read reaching its end
+// when it does not match the digests sent along, so it is not stored
+type bodyReader struct {
+	// the body teed into the hashes, one byte past max
+	r        io.Reader
+	sha, md5 hash.Hash
+	n        int64
+	max      int64
+	wantSha  string
+	wantMD5  string
+	// what went wrong with the body, as opposed to storing it
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	switch {
+	case b.n > b.max:
+		b.err = errEntityTooLarge
+	case err == io.EOF:
+		if b.err = checkDigest(b.wantMD5, b.md5, base64.StdEncoding.EncodeToString, errBadDigest); b.err == nil {
+			b.err = checkDigest(b.wantSha, b.sha, hex.EncodeToString, errSha256Mismatch)
+		}
+	case err != nil:
+		b.err = err
+	}
+	if b.err != nil {
+		return n, b.err
+	}
+	return n, err
+}
+
+func checkDigest(want string, h hash.Hash, encode func([]byte) string, mismatch error) error {
+	if want != "" && want != encode(h.Sum(nil)) {
+		return mismatch
+	}
+	return nil
+}
+
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	okey, err := g.lookupObject(bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	chunk, sum, err := g.readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rec := &objectRecord{
+		Size:        chunk.Size,
+		ETag:        hex.EncodeToString(sum),
+		ContentType: r.Header.Get("Content-Type"),
+		Modified:    time.Now().UTC(),
+		Meta:        userMeta(r.Header),
+		Chunks:      []chunkRef{chunk},
+	}
+	if err := g.replaceObject(okey, rec); err != nil {
+		g.db.Delete(chunk.Key)
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+rec.ETag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// replaceObject stores the record of an object, then drops the chunks of the
+// object it replaces. rec nil deletes the object.
+func (g *Gateway) replaceObject(okey string, rec *objectRecord) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	old := &objectRecord{}
+	err := g.getRecord(okey, old)
+	if err != nil && err != mutcask.ErrNotFound {
+		return err
+	}
+	if rec != nil {
+		err = g.putRecord(okey, rec)
+	} else if err == nil {
+		err = g.db.Delete(okey)
+	}
+	if err != nil {
+		return err
+	}
+	g.dropChunks(old.Chunks)
+	return nil
+}
+
+// dropChunks deletes chunks no record refers to anymore, a chunk left behind
+// only costs space
+func (g *Gateway) dropChunks(chunks []chunkRef) {
+	for _, c := range chunks {
+		g.db.Delete(c.Key)
+	}
+}
+
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	okey, err := g.lookupObject(bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	rec, err := g.getObjectRecord(okey)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	hd := w.Header()
+	hd.Set("ETag", `"`+rec.ETag+`"`)
+	hd.Set("Last-Modified", rec.Modified.Format(http.TimeFormat))
+	hd.Set("Accept-Ranges", "bytes")
+	if rec.ContentType != "" {
+		hd.Set("Content-Type", rec.ContentType)
+	} else {
+		hd.Set("Content-Type", "binary/octet-stream")
+	}
+	for k, v := range rec.Meta {
+		hd.Set(metaHeaderPrefix+k, v)
+	}
+	offset, length, ranged, err := httpapi.ParseRange(r.Header.Get("Range"), rec.Size)
+	if err != nil {
+		hd.Set("Content-Range", fmt.Sprintf("bytes */%d", rec.Size))
+		writeError(w, r, errInvalidRange)
+		return
+	}
+	hd.Set("Content-Length", strconv.FormatInt(length, 10))
+	status := http.StatusOK
+	if ranged {
+		hd.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, rec.Size))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	for _, c := range rec.Chunks {
+		if length == 0 {
+			break
+		}
+		if offset >= c.Size {
+			offset -= c.Size
+			continue
+		}
+		n := c.Size - offset
+		if n > length {
+			n = length
+		}
+		// the status is out, a failing read can only cut the body short
+		if _, err := mutcask.ReadRange(g.db, c.Key, w, offset, n); err != nil {
+			return
+		}
+		offset, length = 0, length-n
+	}
+}
+
+func (g *Gateway) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	okey, err := g.lookupObject(bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := g.replaceObject(okey, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func userMeta(h http.Header) map[string]string {
+	var meta map[string]string
+	for k, v := range h {
+		if !strings.HasPrefix(k, metaHeaderPrefix) || len(v) == 0 {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[strings.ToLower(strings.TrimPrefix(k, metaHeaderPrefix))] = v[0]
+	}
+	return meta
+}
+
+type uploadRecord struct {
+	Bucket      string            `cbor:"b"`
+	Key         string            `cbor:"k"`
+	ContentType string            `cbor:"t,omitempty"`
+	Meta        map[string]string `cbor:"x,omitempty"`
+	Created     time.Time         `cbor:"c"`
+}
+
+type partRecord struct {
+	Chunk chunkRef `cbor:"c"`
+	ETag  string   `cbor:"e"`
+}
+
+// parts of a multipart upload other than the last have to be this large
+const minPartSize = 5 << 20
+
+const maxPartNumber = 10000
+
+func partKey(uploadID string, part int) string {
+	return fmt.Sprintf("%s%s/%05d", partPrefix, uploadID, part)
+}
+
+type initiateUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (g *Gateway) createUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if _, err := g.lookupObject(bucket, key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id := newID()
+	err := g.putRecord(uploadPrefix+id, &uploadRecord{
+		Bucket:      bucket,
+		Key:         key,
+		ContentType: r.Header.Get("Content-Type"),
+		Meta:        userMeta(r.Header),
+		Created:     time.Now().UTC(),
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeXML(w, http.StatusOK, &initiateUploadResult{Bucket: bucket, Key: key, UploadID: id})
+}
+
+func (g *Gateway) getUpload(uploadID, bucket, key string) (*uploadRecord, error) {
+	up := &uploadRecord{}
+	if err := g.getRecord(uploadPrefix+uploadID, up); err != nil {
+		if err == mutcask.ErrNotFound || err == mutcask.ErrKeySizeTooLong {
+			return nil, errNoSuchUpload
+		}
+		return nil, err
+	}
+	if up.Bucket != bucket || up.Key != key {
+		return nil, errNoSuchUpload
+	}
+	return up, nil
+}
+
+func (g *Gateway) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	q := r.URL.Query()
+	part, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil || part < 1 || part > maxPartNumber {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	uploadID := q.Get("uploadId")
+	if _, err := g.getUpload(uploadID, bucket, key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	chunk, sum, err := g.readBody(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	pr := &partRecord{Chunk: chunk, ETag: hex.EncodeToString(sum)}
+	if err := g.replacePart(partKey(uploadID, part), pr); err != nil {
+		g.db.Delete(chunk.Key)
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+pr.ETag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// replacePart stores a part, dropping the one uploaded before under the same
+// number
+func (g *Gateway) replacePart(pkey string, pr *partRecord) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	old := &partRecord{}
+	err := g.getRecord(pkey, old)
+	if err != nil && err != mutcask.ErrNotFound {
+		return err
+	}
+	if err := g.putRecord(pkey, pr); err != nil {
+		return err
+	}
+	if old.Chunk.Key != "" {
+		g.db.Delete(old.Chunk.Key)
+	}
+	return nil
+}
+
+type completeUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func (g *Gateway) completeUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	okey, err := g.lookupObject(bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	uploadID := r.URL.Query().Get("uploadId")
+	up, err := g.getUpload(uploadID, bucket, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	req := &completeUpload{}
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(req); err != nil || len(req.Parts) == 0 {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+	rec := &objectRecord{
+		ContentType: up.ContentType,
+		Modified:    time.Now().UTC(),
+		Meta:        up.Meta,
+	}
+	used := make(map[string]bool)
+	digests := sha256.New()
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, r, errInvalidPartOrder)
+			return
+		}
+		if p.PartNumber < 1 || p.PartNumber > maxPartNumber {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+		pkey := partKey(uploadID, p.PartNumber)
+		pr := &partRecord{}
+		if err := g.getRecord(pkey, pr); err != nil {
+			if err == mutcask.ErrNotFound {
+				err = errInvalidPart
+			}
+			writeError(w, r, err)
+			return
+		}
+		if strings.Trim(p.ETag, `"`) != pr.ETag {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+		if i < len(req.Parts)-1 && pr.Chunk.Size < minPartSize {
+			writeError(w, r, errEntityTooSmall)
+			return
+		}
+		sum, err := hex.DecodeString(pr.ETag)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		digests.Write(sum)
+		used[pkey] = true
+		rec.Chunks = append(rec.Chunks, pr.Chunk)
+		rec.Size += pr.Chunk.Size
+	}
+	rec.ETag = fmt.Sprintf("%s-%d", hex.EncodeToString(digests.Sum(nil)), len(req.Parts))
+	if err := g.replaceObject(okey, rec); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// the listed parts belong to the object now, the others go with the upload
+	if err := g.dropUpload(r, uploadID, used); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeXML(w, http.StatusOK, &completeUploadResult{
+		Location: "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     `"` + rec.ETag + `"`,
+	})
+}
+
+func (g *Gateway) abortUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	uploadID := r.URL.Query().Get("uploadId")
+	if _, err := g.getUpload(uploadID, bucket, key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := g.dropUpload(r, uploadID, nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dropUpload deletes an upload with its parts, the chunks of parts in keep
+// are left in place
+func (g *Gateway) dropUpload(r *http.Request, uploadID string, keep map[string]bool) error {
+	pkeys, err := g.listKeys(r.Context(), partPrefix+uploadID+"/", "", 0)
+	if err != nil {
+		return err
+	}
+	for _, pkey := range pkeys {
+		if !keep[pkey] {
+			pr := &partRecord{}
+			if err := g.getRecord(pkey, pr); err == nil {
+				g.db.Delete(pr.Chunk.Key)
+			}
+		}
+		if err := g.db.Delete(pkey); err != nil && !errors.Is(err, mutcask.ErrNotFound) {
+			return err
+		}
+	}
+	return g.db.Delete(uploadPrefix + uploadID)
+}
@@ -0,0 +1,369 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// how often an in-progress pass persists its cursor
+const scrubCursorSaveInterval = 5 * time.Second
+
+var scrubCursorKey = sysKey(nsScrub, "cursor")
+
+// ScrubProgress reports how far the scrubber went through the index
+type ScrubProgress struct {
+	Running bool
+	// number of passes completed since open
+	Passes uint64
+	// last key verified by the current pass
+	Cursor string
+	// keys and value bytes verified by the current pass
+	Scanned uint64
+	Bytes   uint64
+	// corrupt keys found by the current pass
+	Corrupted uint64
+	// time the last pass completed
+	LastPass time.Time
+}
+
+// QuarantineEntry records a key whose value failed verification
+type QuarantineEntry struct {
+	Key    string
+	Reason string
+	Time   int64
+	// index of the rotten chunk of a value split into chunks, which
+	// RepairChunk writes again, -1 when the whole value is rotten
+	Chunk int `cbor:"-"`
+	// number of the rotten version kept of the value, 0 when the current
+	// value is rotten
+	Version uint64 `cbor:"-"`
+}
+
+type scrubber struct {
+	m *mutcask
+	// one pass at a time
+	run      sync.Mutex
+	mu       sync.Mutex
+	progress ScrubProgress
+}
+
+// Scrub runs one verification pass over every live value, resuming from the
+// cursor left by an interrupted pass. Corrupt keys are quarantined and
+// reported to the OnCorrupt handler.
+func (m *mutcask) Scrub(ctx context.Context) error {
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	return m.scrub.pass(ctx)
+}
+
+func (m *mutcask) ScrubProgress() ScrubProgress {
+	m.scrub.mu.Lock()
+	defer m.scrub.mu.Unlock()
+	return m.scrub.progress
+}
+
+// Quarantined lists keys the scrubber found corrupt. A key leaves the list
+// once it is written again or deleted.
+func (m *mutcask) Quarantined() ([]QuarantineEntry, error) {
+	db, release := m.index()
+	defer release()
+	iter := db.NewIterator(sysRange(nsQuarantine), nil)
+	defer iter.Release()
+	var ret []QuarantineEntry
+	for iter.Next() {
+		qe := QuarantineEntry{}
+		if err := cbor.Unmarshal(iter.Value(), &qe); err != nil {
+			return nil, err
+		}
+		qe.Key, qe.Chunk = sysKeySuffix(iter.Key()), -1
+		if qe.Key != "" && qe.Key[0] == sysPrefix {
+			if key, v, ok := parseVersionKey([]byte(qe.Key)); ok {
+				qe.Key, qe.Version = key, v
+			} else if _, idx, key, ok := parseChunkKey([]byte(qe.Key)); ok {
+				qe.Key, qe.Chunk = key, int(idx)
+			}
+		}
+		ret = append(ret, qe)
+	}
+	return ret, iter.Error()
+}
+
+func (m *mutcask) Unquarantine(key string) error {
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	return m.keys.Delete(sysKey(nsQuarantine, key), nil)
+}
+
+func (m *mutcask) startScrubber() {
+	if m.cfg.ScrubInterval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.bg.Add(1)
+	go func() {
+		defer m.bg.Done()
+		<-m.closeChan
+		cancel()
+	}()
+	m.bg.Add(1)
+	go func() {
+		defer m.bg.Done()
+		for {
+			if err := m.scrub.pass(ctx); err != nil && ctx.Err() == nil {
+				m.cfg.Logger.Error("scrub pass", "err", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(m.cfg.ScrubInterval):
+			}
+		}
+	}()
+}
+
+func (s *scrubber) pass(ctx context.Context) (err error) {
+	s.run.Lock()
+	defer s.run.Unlock()
+
+	cursor, err := s.loadCursor()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.progress.Running = true
+	s.progress.Cursor = cursor
+	if cursor == "" {
+		s.progress.Scanned, s.progress.Bytes, s.progress.Corrupted = 0, 0, 0
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.progress.Running = false
+		s.mu.Unlock()
+		if err != nil {
+			s.saveCursor(cursor)
+		}
+	}()
+
+	files := make(map[uint32]*os.File)
+	defer func() {
+		for _, fh := range files {
+			if fh != nil {
+				fh.Close()
+			}
+		}
+	}()
+	limiter := newRateLimiter(s.m.cfg.ScrubRate)
+	lastSave := time.Now()
+	for _, rng := range valueRanges() {
+		if cursor != "" {
+			// chunks sort before user keys, a pass resumes in the range it
+			// was in
+			next := append([]byte(cursor), 0)
+			if rng.Limit != nil && bytes.Compare(next, rng.Limit) >= 0 {
+				continue
+			}
+			if bytes.Compare(next, rng.Start) > 0 {
+				rng.Start = next
+			}
+		}
+		iter := s.m.keys.NewIterator(rng, nil)
+		for iter.Next() {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			key := string(iter.Key())
+			n, verr := s.verify(files, key, iter.Value())
+			if verr != nil && !isCorruption(verr) {
+				err = verr
+				break
+			}
+			if verr != nil {
+				if err = s.quarantine(key, iter.Value(), verr); err != nil {
+					break
+				}
+			}
+			cursor = key
+			s.mu.Lock()
+			s.progress.Cursor = key
+			s.progress.Scanned++
+			s.progress.Bytes += uint64(n)
+			if verr != nil {
+				s.progress.Corrupted++
+			}
+			s.mu.Unlock()
+			if time.Since(lastSave) > scrubCursorSaveInterval {
+				if err = s.saveCursor(cursor); err != nil {
+					break
+				}
+				lastSave = time.Now()
+			}
+			if err = limiter.wait(ctx, n); err != nil {
+				break
+			}
+		}
+		iter.Release()
+		if err == nil {
+			err = iter.Error()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// pass completed, next one starts over
+	if err = s.m.keys.Delete(scrubCursorKey, nil); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.progress.Passes++
+	s.progress.Cursor = ""
+	s.progress.LastPass = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// verify checks the value an index entry points to, returning the number of
+// bytes read
+func (s *scrubber) verify(files map[uint32]*os.File, key string, hd []byte) (int, error) {
+	hlv, err := HintLVFromBytes(hd)
+	if err != nil {
+		return 0, ErrHintFormat
+	}
+	if hlv.Inline {
+		// leveldb checks the blocks holding the entry
+		return len(hlv.Value), nil
+	}
+	if hlv.Chunks != nil {
+		// the chunks are verified as entries of their own
+		return 0, nil
+	}
+	id := s.m.fileID(key)
+	if hlv.Digest != nil {
+		rec, err := getDigest(s.m.keys, hlv.Digest)
+		if err != nil {
+			return 0, ErrHintFormat
+		}
+		id, hlv = rec.Cask, rec.hintLV()
+	}
+	fh, ok := files[id]
+	if !ok {
+		fh, err = os.Open(s.m.vLogPath(id))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		files[id] = fh
+	}
+	if fh == nil {
+		return 0, ErrReadHintBeyondRange
+	}
+	buf, err := readRecord(fh, &Hint{Key: key, VOffset: hlv.VOffset, VSize: hlv.VSize})
+	if err != nil {
+		return 0, err
+	}
+	defer vBuf.Put(buf)
+	return len(*buf), VerifyValue(*buf)
+}
+
+func (s *scrubber) quarantine(key string, hd []byte, reason error) error {
+	// the key may have been rewritten since the pass started, only the
+	// value the iterator saw is known to be corrupt
+	cur, err := s.m.keys.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound || (err == nil && !bytes.Equal(cur, hd)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	qd, err := cbor.Marshal(&QuarantineEntry{
+		Reason: reason.Error(),
+		Time:   time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	if err = s.m.keys.Put(sysKey(nsQuarantine, key), qd, nil); err != nil {
+		return err
+	}
+	id := s.m.fileID(key)
+	if key[0] == sysPrefix {
+		if parent, v, ok := parseVersionKey([]byte(key)); ok {
+			key, reason = parent, fmt.Errorf("version %d: %w", v, reason)
+		} else if _, idx, parent, ok := parseChunkKey([]byte(key)); ok {
+			key, reason = parent, fmt.Errorf("chunk %d: %w", idx, reason)
+		}
+	}
+	attrs := []any{"key", key, "cask", id, "err", reason}
+	if hlv, err := HintLVFromBytes(hd); err == nil {
+		attrs = append(attrs, "offset", hlv.VOffset)
+	}
+	s.m.cfg.Logger.Warn("quarantined corrupt value", attrs...)
+	if s.m.cfg.OnCorrupt != nil {
+		s.m.cfg.OnCorrupt(key, reason)
+	}
+	return nil
+}
+
+func (s *scrubber) loadCursor() (string, error) {
+	d, err := s.m.keys.Get(scrubCursorKey, nil)
+	if err == leveldb.ErrNotFound {
+		return "", nil
+	}
+	return string(d), err
+}
+
+func (s *scrubber) saveCursor(cursor string) error {
+	if cursor == "" {
+		return nil
+	}
+	return s.m.keys.Put(scrubCursorKey, []byte(cursor), nil)
+}
+
+func isCorruption(err error) bool {
+	switch err {
+	case ErrDataRotted, ErrValueFormat, ErrHintFormat, ErrReadHintBeyondRange:
+		return true
+	}
+	return false
+}
+
+// rateLimiter paces reads to an average of rate bytes per second
+type rateLimiter struct {
+	rate  float64
+	start time.Time
+	done  float64
+}
+
+func newRateLimiter(rate int) *rateLimiter {
+	return &rateLimiter{
+		rate:  float64(rate),
+		start: time.Now(),
+	}
+}
+
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	l.done += float64(n)
+	d := time.Until(l.start.Add(time.Duration(l.done / l.rate * float64(time.Second))))
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,80 @@
+package mutcask
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+	var kvdata = []kvt{
+		{"Qmc35RPEYrW3Mj1mki6thkAjx6a1ZFkU3UYxAyFhMmngr2", []byte("124567")},
+		{"QmTwNzgUFg2kCZ47AmsKUDHwnfAhcGj6TB4mNZcott9zWc", []byte("224567")},
+		{"QmYgPV5bT37u56qePZUqLQ15JhnopaSmVx8ao39RUCoZEj", []byte("324567")},
+	}
+	dir := tmpdirpath(t)
+	var reported []string
+	mutc, err := NewMutcask(PathConf(dir), CaskNumConf(1), OnCorruptConf(func(key string, err error) {
+		reported = append(reported, key)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range kvdata {
+		if err := mutc.Put(item.Key, item.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// flip the last byte of the second value
+	hint, err := get_hint(mutc.keys, kvdata[1].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh, err := os.OpenFile(mutc.vLogPath(0), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fh.WriteAt([]byte{'x'}, int64(hint.VOffset+uint64(hint.VSize)-1)); err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+
+	if err := mutc.Scrub(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	p := mutc.ScrubProgress()
+	if p.Passes != 1 || p.Scanned != uint64(len(kvdata)) || p.Corrupted != 1 {
+		t.Fatalf("unexpected progress %#v", p)
+	}
+	if len(reported) != 1 || reported[0] != kvdata[1].Key {
+		t.Fatalf("unexpected corrupt report %v", reported)
+	}
+	mutc.Close()
+
+	// quarantine survives restart
+	mutc, err = NewMutcask(PathConf(dir), CaskNumConf(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mutc.Close()
+	qs, err := mutc.Quarantined()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qs) != 1 || qs[0].Key != kvdata[1].Key || qs[0].Reason != ErrDataRotted.Error() {
+		t.Fatalf("unexpected quarantine %#v", qs)
+	}
+
+	// re-fetched value leaves the quarantine
+	if err := mutc.Put(kvdata[1].Key, kvdata[1].Value); err != nil {
+		t.Fatal(err)
+	}
+	qs, err = mutc.Quarantined()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qs) != 0 {
+		t.Fatalf("quarantine should be empty, got %#v", qs)
+	}
+}
@@ -0,0 +1,127 @@
+package mutcask
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// index entries written per batch when copying the index
+const snapshotBatchSize = 4096
+
+// Snapshot writes a consistent copy of the repo into dir, which must not
+// exist or be empty. The copy opens with NewMutcask like any other repo.
+//
+// Writers are not blocked: the index is read from a leveldb snapshot, and
+// since values are appended before their index entry is written, every value
+// the snapshot refers to lies below the vlog sizes taken right after it.
+// Vlogs are copied up to those sizes rather than linked, as the writer keeps
+// appending to them. Compaction waits until the snapshot is done.
+func (m *mutcask) Snapshot(dir string) (err error) {
+	m.maint.Lock()
+	defer m.maint.Unlock()
+
+	db, vlogs, release := m.indexVLogs()
+	defer release()
+	snap, err := db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+	cutoffs := make(map[uint32]uint64)
+	for _, id := range m.caskIDs() {
+		cask, _ := m.caskMap.Get(id)
+		cutoffs[id] = atomic.LoadUint64(&cask.vLogSize)
+	}
+
+	if err = os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	dirents, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(dirents) > 0 {
+		return ErrPath
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	for id, size := range cutoffs {
+		var in *os.File
+		if in, err = m.openVLog(vlogs, id); err != nil {
+			return err
+		}
+		err = copyVLog(in, filepath.Join(dir, VLogName(id)), size)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	dst, err := leveldb.OpenFile(filepath.Join(dir, keys_dir), nil)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	iter := snap.NewIterator(nil, nil)
+	defer iter.Release()
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		if skipInSnapshot(iter.Key()) {
+			continue
+		}
+		batch.Put(iter.Key(), iter.Value())
+		if batch.Len() >= snapshotBatchSize {
+			if err = dst.Write(batch, nil); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err = iter.Error(); err != nil {
+		return err
+	}
+	if err = dst.Write(batch, nil); err != nil {
+		return err
+	}
+
+	meta, err := ReadRepoMeta(m.cfg.Path)
+	if err != nil {
+		return err
+	}
+	return writeRepoMeta(dir, &RepoMeta{
+		Version: RepoVersion,
+		CaskNum: m.cfg.CaskNum,
+		Keys:    meta.Keys,
+	})
+}
+
+// skipInSnapshot drops internal records which only make sense for the
+// running repo
+func skipInSnapshot(key []byte) bool {
+	return bytes.Equal(key, scrubCursorKey) || bytes.HasPrefix(key, []byte{sysPrefix, nsCompact})
+}
+
+func copyVLog(in *os.File, dst string, size uint64) error {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(out, in, int64(size)); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,74 @@
+package mutcask
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	mutc, err := NewMutcask(PathConf(tmpdirpath(t)), CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mutc.Close()
+	for i := 0; i < 100; i++ {
+		if err := mutc.Put(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("value-%03d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// keep writing while the snapshot is taken
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 100; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			mutc.Put(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("value-%03d", i)))
+		}
+	}()
+	dir := filepath.Join(tmpdirpath(t), "snap")
+	err = mutc.Snapshot(dir)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := NewMutcask(PathConf(dir), CaskNumConf(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := snap.ListKeys("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) < 100 {
+		t.Fatalf("snapshot should hold at least 100 keys, got %d", len(keys))
+	}
+	for _, key := range keys {
+		v, err := snap.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v, []byte("value-"+key[4:])) {
+			t.Fatalf("unexpected value %s of %s", v, key)
+		}
+	}
+	snap.Close()
+	report, err := Fsck(dir, FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Errors() != 0 {
+		t.Fatalf("snapshot should be consistent, got %d errors", report.Errors())
+	}
+}
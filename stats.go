@@ -0,0 +1,377 @@
+package mutcask
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+type CaskStats struct {
+	ID        uint32
+	Keys      uint64
+	VLogBytes uint64
+	// bytes of values still referenced by a key
+	LiveBytes uint64
+	// size of those values before compression
+	LogicalBytes uint64
+}
+
+type Stats struct {
+	Keys         uint64
+	VLogBytes    uint64
+	LiveBytes    uint64
+	LogicalBytes uint64
+	Casks        []CaskStats
+}
+
+// DeadRatio is the share of vlog bytes compaction could reclaim
+func (s *Stats) DeadRatio() float64 {
+	if s.VLogBytes == 0 {
+		return 0
+	}
+	return float64(s.VLogBytes-s.LiveBytes) / float64(s.VLogBytes)
+}
+
+// Stats walks the whole index to sum up live bytes per cask
+func (m *mutcask) Stats() (*Stats, error) {
+	ids := m.caskIDs()
+	casks := make(map[uint32]*CaskStats, len(ids))
+	for _, id := range ids {
+		cask, _ := m.caskMap.Get(id)
+		casks[id] = &CaskStats{
+			ID:        id,
+			VLogBytes: atomic.LoadUint64(&cask.vLogSize),
+		}
+	}
+	st := &Stats{}
+	db, release := m.index()
+	defer release()
+	iter := db.NewIterator(userRange(), nil)
+	defer iter.Release()
+	for iter.Next() {
+		hlv, err := HintLVFromBytes(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		st.Keys++
+		cs, ok := casks[m.fileID(string(iter.Key()))]
+		if !ok {
+			continue
+		}
+		cs.Keys++
+		if hlv.Digest != nil {
+			// counted once below, in the cask storing it
+			rec, err := getDigest(db, hlv.Digest)
+			if err != nil {
+				continue
+			}
+			hlv = rec.hintLV()
+		} else if hlv.Chunks == nil {
+			cs.LiveBytes += uint64(hlv.VSize)
+		}
+		cs.LogicalBytes += hlv.valueSize()
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	// chunks and versions only count in the cask storing them, the value of
+	// chunks is counted logically above
+	for _, ns := range []byte{nsChunk, nsVersion} {
+		iter := db.NewIterator(sysRange(ns), nil)
+		for iter.Next() {
+			hlv, err := HintLVFromBytes(iter.Value())
+			if err != nil {
+				iter.Release()
+				return nil, err
+			}
+			if cs, ok := casks[m.fileID(string(iter.Key()))]; ok && hlv.Digest == nil && hlv.Chunks == nil {
+				cs.LiveBytes += uint64(hlv.VSize)
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+	}
+	shared := db.NewIterator(sysRange(nsDigest), nil)
+	defer shared.Release()
+	for shared.Next() {
+		rec := &digestRecord{}
+		if err := cbor.Unmarshal(shared.Value(), rec); err != nil {
+			return nil, ErrHintFormat
+		}
+		if cs, ok := casks[rec.Cask]; ok {
+			cs.LiveBytes += uint64(rec.VSize)
+		}
+	}
+	if err := shared.Error(); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		cs := casks[id]
+		st.VLogBytes += cs.VLogBytes
+		st.LiveBytes += cs.LiveBytes
+		st.LogicalBytes += cs.LogicalBytes
+		st.Casks = append(st.Casks, *cs)
+	}
+	return st, nil
+}
+
+// CaskGauges are the counters of a cask which change with every write
+type CaskGauges struct {
+	ID uint32
+	// actions waiting for the cask goroutine
+	Pending   int64
+	VLogBytes uint64
+}
+
+// CompactProgress tells how far a running compaction got, all zero when
+// none is running
+type CompactProgress struct {
+	CasksDone  uint32
+	CasksTotal uint32
+	// live bytes of the cask being rewritten
+	BytesCopied uint64
+	BytesTotal  uint64
+}
+
+type Gauges struct {
+	Casks      []CaskGauges
+	Compaction CompactProgress
+}
+
+// Gauges reads counters kept up to date as the repo is used, unlike Stats it
+// does not walk the index
+func (m *mutcask) Gauges() *Gauges {
+	g := &Gauges{
+		Compaction: CompactProgress{
+			CasksDone:  atomic.LoadUint32(&m.compactDone),
+			CasksTotal: atomic.LoadUint32(&m.compactCasks),
+		},
+	}
+	for _, id := range m.caskIDs() {
+		cask, has := m.caskMap.Get(id)
+		if !has {
+			continue
+		}
+		g.Casks = append(g.Casks, CaskGauges{
+			ID:        id,
+			Pending:   atomic.LoadInt64(&cask.pending),
+			VLogBytes: atomic.LoadUint64(&cask.vLogSize),
+		})
+		g.Compaction.BytesCopied += atomic.LoadUint64(&cask.compactCopied)
+		g.Compaction.BytesTotal += atomic.LoadUint64(&cask.compactTotal)
+	}
+	return g
+}
+
+// KeyStat tells where the value of a key is stored
+type KeyStat struct {
+	Key    string
+	Cask   uint32
+	Offset uint64
+	// logical value size
+	Size int
+	// number of chunks of a value split into chunks, which are stored
+	// elsewhere
+	Chunks uint32
+	// the value is kept in the index entry
+	Inline bool
+	// crc32 recorded in front of the value
+	CRC uint32
+	// zero unless the key was put with a TTL
+	Expires time.Time
+	// number of the value in versioned mode
+	Version uint64
+	// hex sha256 of the value as recorded when it was put, empty unless it
+	// was put with PutOptions.Checksum, deduplicated or inline. CheckSum
+	// reads the value for the others.
+	Checksum string
+	// when the key was first and last written, zero for keys written
+	// before they were recorded. Writing a key again keeps its creation
+	// time.
+	Created  time.Time
+	Modified time.Time
+	Meta     map[string]string
+}
+
+// Stat reads the index entry and the record header of key, the value itself
+// is not read. So the checksum is only reported when it was recorded at put
+// time.
+func (m *mutcask) Stat(key string) (*KeyStat, error) {
+	hint, id, fh, release, err := m.acquire(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	st := &KeyStat{
+		Key:      key,
+		Cask:     id,
+		Size:     int(hint.valueSize()),
+		Expires:  unixTime(hint.Expires),
+		Created:  unixTime(hint.Created),
+		Modified: unixTime(hint.Modified),
+		Meta:     hint.Meta,
+		Version:  hint.Version,
+	}
+	switch {
+	case hint.Digest != nil:
+		st.Checksum = hex.EncodeToString(hint.Digest)
+	case hint.Sum != nil:
+		st.Checksum = hex.EncodeToString(hint.Sum)
+	case hint.Inline:
+		sum := sha256.Sum256(hint.Value)
+		st.Checksum = hex.EncodeToString(sum[:])
+	}
+	if hint.Inline {
+		st.Inline = true
+		return st, nil
+	}
+	if hint.Chunks != nil {
+		st.Chunks = hint.Chunks.Count
+		return st, nil
+	}
+	var crc [4]byte
+	if _, err := fh.ReadAt(crc[:], int64(hint.VOffset)); err != nil {
+		return nil, err
+	}
+	st.Offset = hint.VOffset
+	st.CRC = binary.LittleEndian.Uint32(crc[:])
+	return st, nil
+}
+
+// unixTime is the time of unix nanoseconds ns, zero for zero
+func unixTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// ListKeys returns up to limit keys with prefix which sort after start, in
+// key order. Limit <= 0 means no limit.
+func (m *mutcask) ListKeys(prefix, start string, limit int) ([]string, error) {
+	rng := userRange()
+	if prefix != "" {
+		rng.Start = []byte(prefix)
+	}
+	if start != "" && start >= prefix {
+		rng.Start = append([]byte(start), 0)
+	}
+	db, release := m.index()
+	defer release()
+	iter := db.NewIterator(rng, nil)
+	defer iter.Release()
+	var keys []string
+	now := time.Now()
+	for iter.Next() {
+		key := string(iter.Key())
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		if !m.liveEntry(iter.Value(), now) {
+			continue
+		}
+		keys = append(keys, key)
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+	}
+	return keys, iter.Error()
+}
+
+// RecordInfo describes one record of a vlog
+type RecordInfo struct {
+	Key    string
+	Offset uint64
+	// encoded size, including the crc
+	Size uint32
+	// logical size of a compressed or sealed value, zero for values stored
+	// as is
+	LSize uint32
+	// key a sealed record is sealed with, an index into RepoMeta.Keys plus one
+	EncKey uint32
+}
+
+// Records lists the records of a cask the index refers to, in vlog order.
+// Shared values are listed once, under "sha256:" and their hex digest, chunks
+// under the key of their value, "#" and their index.
+func (m *mutcask) Records(id uint32) ([]RecordInfo, error) {
+	db, release := m.index()
+	defer release()
+	var recs []RecordInfo
+	for _, rng := range valueRanges() {
+		iter := db.NewIterator(rng, nil)
+		for iter.Next() {
+			key := string(iter.Key())
+			if m.fileID(key) != id {
+				continue
+			}
+			hlv, err := HintLVFromBytes(iter.Value())
+			if err != nil {
+				iter.Release()
+				return nil, err
+			}
+			if hlv.Digest != nil || hlv.Chunks != nil || hlv.Inline {
+				continue
+			}
+			if key[0] == sysPrefix {
+				if parent, v, ok := parseVersionKey(iter.Key()); ok {
+					key = fmt.Sprintf("%s@%d", parent, v)
+				} else if _, idx, parent, ok := parseChunkKey(iter.Key()); ok {
+					key = fmt.Sprintf("%s#%d", parent, idx)
+				}
+			}
+			recs = append(recs, RecordInfo{
+				Key:    key,
+				Offset: hlv.VOffset,
+				Size:   hlv.VSize,
+				LSize:  hlv.LSize,
+				EncKey: hlv.EncKey,
+			})
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+	}
+	shared := db.NewIterator(sysRange(nsDigest), nil)
+	defer shared.Release()
+	for shared.Next() {
+		rec := &digestRecord{}
+		if err := cbor.Unmarshal(shared.Value(), rec); err != nil {
+			return nil, ErrHintFormat
+		}
+		if rec.Cask != id {
+			continue
+		}
+		recs = append(recs, RecordInfo{
+			Key:    "sha256:" + hex.EncodeToString([]byte(sysKeySuffix(shared.Key()))),
+			Offset: rec.VOffset,
+			Size:   rec.VSize,
+			LSize:  rec.LSize,
+			EncKey: rec.EncKey,
+		})
+	}
+	if err := shared.Error(); err != nil {
+		return nil, err
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Offset < recs[j].Offset })
+	return recs, nil
+}
+
+// Migrate imports the keys of legacy hint logs into the index
+func (m *mutcask) Migrate() error {
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	return doMigrate(m.cfg, m.keys)
+}
@@ -0,0 +1,67 @@
+package mutcask
+
+import (
+	"context"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// syncer is implemented by KVDBs which can flush their writes to stable
+// storage
+type syncer interface {
+	Sync() error
+}
+
+// Sync flushes what was written to db to stable storage, KVDBs which can't
+// fail with ErrNoSupport
+func Sync(db KVDB) error {
+	if s, ok := db.(syncer); ok {
+		return s.Sync()
+	}
+	return ErrNoSupport
+}
+
+// syncKey is deleted by a synced write to flush the index, it is never set
+var syncKey = sysKey(nsGen, "sync")
+
+// Sync fsyncs the vlogs of all casks and then the index, whatever was written
+// before it is called survives a crash once it returns
+func (m *mutcask) Sync() (err error) {
+	_, span := m.tracer.Start(context.Background(), "mutcask.Sync")
+	defer func() { endSpan(span, err) }()
+	if m.cfg.ReadOnly {
+		return nil
+	}
+	for _, id := range m.caskIDs() {
+		cask, has := m.caskMap.Get(id)
+		if !has {
+			continue
+		}
+		cask.swap.RLock()
+		err = cask.vLog.Sync()
+		cask.swap.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+	// goleveldb flushes its journal only on a synced write
+	batch := new(leveldb.Batch)
+	batch.Delete(syncKey)
+	return m.keys.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+func (kv *levedbKV) Sync() error {
+	batch := new(leveldb.Batch)
+	batch.Delete(syncKey)
+	return kv.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// Sync has nothing to flush, memkv keeps no files
+func (mkv *memkv) Sync() error {
+	return nil
+}
+
+func (kv *cachedMutcask) Sync() error {
+	return Sync(kv.db)
+}
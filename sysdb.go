@@ -0,0 +1,63 @@
+package mutcask
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Internal records share the leveldb index with user keys. They live under a
+// reserved leading byte which user keys are not allowed to start with, so
+// iterating from userKeyStart never yields them.
+const sysPrefix = byte(0)
+
+// namespaces of internal records
+const (
+	nsQuarantine = 'q'
+	nsScrub      = 's'
+	nsCompact    = 'k'
+	nsGen        = 'g'
+	nsDeleteLog  = 'd'
+	nsBackup     = 'b'
+	nsDigest     = 'h'
+	nsChunk      = 'c'
+	nsExpiry     = 'e'
+	nsVersion    = 'v'
+)
+
+var userKeyStart = []byte{sysPrefix + 1}
+
+func sysKey(ns byte, key string) []byte {
+	k := make([]byte, 0, 3+len(key))
+	k = append(k, sysPrefix, ns, '/')
+	return append(k, key...)
+}
+
+func sysRange(ns byte) *util.Range {
+	return util.BytesPrefix([]byte{sysPrefix, ns, '/'})
+}
+
+func sysKeySuffix(k []byte) string {
+	return string(k[3:])
+}
+
+func userRange() *util.Range {
+	return &util.Range{Start: userKeyStart}
+}
+
+// valueRanges hold the index entries pointing into vlogs, chunks of large
+// values, versions and user keys, in key order
+func valueRanges() []*util.Range {
+	return []*util.Range{sysRange(nsChunk), sysRange(nsVersion), userRange()}
+}
+
+func checkKey(key string) error {
+	if len(key) == 0 {
+		return ErrKeyEmpty
+	}
+	if len(key) > MaxKeySize {
+		return ErrKeySizeTooLong
+	}
+	if key[0] == sysPrefix {
+		return ErrKeyReserved
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package mutcask
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/filedag-project/mutcask"
+
+var (
+	attrCask   = attribute.Key("mutcask.cask")
+	attrOffset = attribute.Key("mutcask.offset")
+	attrSize   = attribute.Key("mutcask.size")
+)
+
+// startOp starts the span of a KVDB call on key
+func (m *mutcask) startOp(name, key string) (context.Context, trace.Span) {
+	return m.tracer.Start(context.Background(), "mutcask."+name,
+		trace.WithAttributes(attrCask.Int64(int64(m.fileID(key)))))
+}
+
+// startSpan starts a child of the span in ctx, there is none when the call
+// is not traced
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it failed by err
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
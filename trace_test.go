@@ -0,0 +1,104 @@
+package mutcask
+
+import (
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceSpans(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(2), TracerProviderConf(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	value := []byte("traced value")
+	if err := m.Put("key", value); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("missing"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	byName := func(name string) []tracetest.SpanStub {
+		var ret []tracetest.SpanStub
+		for _, s := range spans {
+			if s.Name == name {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	attr := func(s tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+		for _, kv := range s.Attributes {
+			if kv.Key == key {
+				return kv.Value, true
+			}
+		}
+		return attribute.Value{}, false
+	}
+
+	put := byName("mutcask.Put")
+	if len(put) != 1 {
+		t.Fatalf("expected one Put span, got %d", len(put))
+	}
+	if v, _ := attr(put[0], attrSize); v.AsInt64() != int64(len(value)) {
+		t.Fatalf("unexpected Put size %v", v)
+	}
+	if v, ok := attr(put[0], attrCask); !ok || v.AsInt64() != int64(m.fileID("key")) {
+		t.Fatalf("unexpected Put cask %v", v)
+	}
+	gets := byName("mutcask.Get")
+	if len(gets) != 2 {
+		t.Fatalf("expected two Get spans, got %d", len(gets))
+	}
+
+	children := map[string]string{
+		"queue.wait":   "mutcask.Put",
+		"vlog.write":   "mutcask.Put",
+		"vlog.read":    "mutcask.Get",
+		"crc.verify":   "mutcask.Get",
+		"index.lookup": "mutcask.Get",
+	}
+	for name, parent := range children {
+		cs := byName(name)
+		if len(cs) == 0 {
+			t.Fatalf("no %s span", name)
+		}
+		for _, c := range cs {
+			found := false
+			for _, p := range byName(parent) {
+				if c.Parent.SpanID() == p.SpanContext.SpanID() {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("%s span is not a child of %s", name, parent)
+			}
+		}
+	}
+	write := byName("vlog.write")[0]
+	if v, ok := attr(write, attrOffset); !ok || v.AsInt64() != 0 {
+		t.Fatalf("unexpected write offset %v", v)
+	}
+	if v, _ := attr(write, attrSize); v.AsInt64() != int64(len(value)+4) {
+		t.Fatalf("unexpected write size %v", v)
+	}
+	if len(byName("vlog.read")) != 1 {
+		t.Fatal("a missing key should not read the vlog")
+	}
+	if gets[1].Status.Code.String() != "Error" {
+		t.Fatalf("missing key Get not marked failed: %v", gets[1].Status)
+	}
+}
@@ -0,0 +1,179 @@
+package mutcask
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// expired entries deleted per cask action by the reaper
+const reapBatchSize = 1024
+
+func (h *HintLV) expired(now time.Time) bool {
+	return h.Expires != 0 && now.UnixNano() >= h.Expires
+}
+
+// expiryKey orders keys with a TTL by their expiry. Records of entries
+// rewritten since are left for the reaper to skip.
+func expiryKey(expires int64, key string) []byte {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(expires))
+	return sysKey(nsExpiry, string(ts[:])+key)
+}
+
+func parseExpiryKey(k []byte) (int64, string) {
+	s := sysKeySuffix(k)
+	if len(s) < 8 {
+		return 0, ""
+	}
+	return int64(binary.BigEndian.Uint64([]byte(s[:8]))), s[8:]
+}
+
+func hasExpiry(db *leveldb.DB) bool {
+	iter := db.NewIterator(sysRange(nsExpiry), nil)
+	defer iter.Release()
+	return iter.Next()
+}
+
+// liveEntry tells whether an index entry is visible to key iteration
+func (m *mutcask) liveEntry(hd []byte, now time.Time) bool {
+	if !m.expiring.Load() {
+		return true
+	}
+	hlv, err := HintLVFromBytes(hd)
+	return err != nil || !hlv.expired(now)
+}
+
+// PutWithTTL stores value under key until ttl has passed
+func (m *mutcask) PutWithTTL(key string, value []byte, ttl time.Duration) error {
+	return m.PutWithOptions(key, value, PutOptions{TTL: ttl})
+}
+
+type expiryItem struct {
+	key     string
+	expires int64
+}
+
+// Reap deletes the keys whose TTL has passed, so compaction can reclaim
+// their values. Reads treat them as gone already.
+func (m *mutcask) Reap(ctx context.Context) error {
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rng := &util.Range{
+			Start: sysRange(nsExpiry).Start,
+			Limit: expiryKey(time.Now().UnixNano()+1, ""),
+		}
+		items := make(map[uint32][]expiryItem)
+		n := 0
+		iter := m.keys.NewIterator(rng, nil)
+		for n < reapBatchSize && iter.Next() {
+			expires, key := parseExpiryKey(iter.Key())
+			id := m.fileID(key)
+			items[id] = append(items[id], expiryItem{key: key, expires: expires})
+			n++
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+		for id, batch := range items {
+			cask, err := m.cask(id)
+			if err != nil {
+				return err
+			}
+			retvc := make(chan retv)
+			err = cask.submit(ctx, &action{
+				optype:   opexpire,
+				expiring: batch,
+				retvchan: retvc,
+			})
+			if err != nil {
+				return err
+			}
+			if ret := <-retvc; ret.err != nil {
+				return ret.err
+			}
+		}
+		if n < reapBatchSize {
+			return nil
+		}
+	}
+}
+
+func (m *mutcask) startReaper() {
+	if m.cfg.ReapInterval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.bg.Add(1)
+	go func() {
+		defer m.bg.Done()
+		<-m.closeChan
+		cancel()
+	}()
+	m.bg.Add(1)
+	go func() {
+		defer m.bg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(m.cfg.ReapInterval):
+			}
+			if !m.expiring.Load() {
+				continue
+			}
+			if err := m.Reap(ctx); err != nil && ctx.Err() == nil {
+				m.cfg.Logger.Error("reap expired keys", "err", err)
+			}
+		}
+	}()
+}
+
+// doexpire deletes the entries of a batch of expired keys, unless they were
+// rewritten since
+func (c *Cask) doexpire(act *action) {
+	var err error
+	defer func() {
+		if err != nil {
+			act.retvchan <- retv{err: err}
+		}
+	}()
+	batch := new(leveldb.Batch)
+	var gone []string
+	for _, item := range act.expiring {
+		batch.Delete(expiryKey(item.expires, item.key))
+		var d []byte
+		d, err = c.keys.Get([]byte(item.key), nil)
+		if err == leveldb.ErrNotFound {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		hlv, herr := HintLVFromBytes(d)
+		if herr != nil || hlv.Expires != item.expires {
+			continue
+		}
+		gone = append(gone, item.key)
+		batch.Delete([]byte(item.key))
+		batch.Delete(sysKey(nsQuarantine, item.key))
+		c.journal(batch, item.key)
+	}
+	if err = c.commit(batch, gone...); err != nil {
+		return
+	}
+	if len(gone) > 0 {
+		c.log.Debug("reaped expired keys", "keys", len(gone))
+	}
+	act.retvchan <- retv{}
+}
@@ -0,0 +1,130 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestTTL(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	// the test reaps by hand
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(2), ReapIntervalConf(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if err := m.Put("keep", []byte("forever")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PutWithTTL("long", []byte("an hour"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"gone", "renewed"} {
+		if err := m.PutWithTTL(k, bytes.Repeat([]byte("x"), 100), 50*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st, err := m.Stat("long"); err != nil || st.Expires.Before(time.Now()) {
+		t.Fatalf("stat %+v: %v", st, err)
+	}
+	if err := m.Put("renewed", []byte("no ttl")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := m.Get("gone"); err != ErrNotFound {
+		t.Fatalf("get expired: %v", err)
+	}
+	if _, err := m.Size("gone"); err != ErrNotFound {
+		t.Fatalf("size expired: %v", err)
+	}
+	if _, err := m.Read("gone", io.Discard); err != ErrNotFound {
+		t.Fatalf("read expired: %v", err)
+	}
+	keys, err := m.ListKeys("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kc, err := m.AllKeysChan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []string
+	for k := range kc {
+		all = append(all, k)
+	}
+	for _, ks := range [][]string{keys, all} {
+		if len(ks) != 3 || ks[0] != "keep" || ks[1] != "long" || ks[2] != "renewed" {
+			t.Fatalf("keys %v", ks)
+		}
+	}
+
+	if err := m.Reap(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.keys.Get([]byte("gone"), nil); err == nil {
+		t.Fatal("expired entry left")
+	}
+	if v, err := m.Get("renewed"); err != nil || string(v) != "no ttl" {
+		t.Fatalf("get renewed %q: %v", v, err)
+	}
+	if _, err := m.Get("long"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	st, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.VLogBytes != st.LiveBytes {
+		t.Fatalf("not reclaimed %+v", st)
+	}
+}
+
+func TestCloseWhileReaping(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(4), ReapIntervalConf(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3*reapBatchSize; i++ {
+		if err := m.PutWithTTL(fmt.Sprintf("key-%d", i), []byte("x"), time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	reaped := make(chan error, 1)
+	go func() { reaped <- m.Reap(context.Background()) }()
+	time.Sleep(time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		m.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("close hangs while reaping")
+	}
+	select {
+	case err := <-reaped:
+		if err != nil && err != ErrClosed && err != leveldb.ErrClosed {
+			t.Fatalf("reap: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("reap hangs once closed")
+	}
+}
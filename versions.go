@@ -0,0 +1,345 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// version records keep the entries of values replaced or deleted in
+// versioned mode. They are keyed by the length of the key, the key and the
+// version number, so the versions of a key sort together and in order.
+func versionPrefix(key string) []byte {
+	k := sysKey(nsVersion, "")
+	k = binary.BigEndian.AppendUint16(k, uint16(len(key)))
+	return append(k, key...)
+}
+
+func versionKey(key string, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(versionPrefix(key), v)
+}
+
+func parseVersionKey(k []byte) (key string, v uint64, ok bool) {
+	if len(k) < 3+2+8 || k[0] != sysPrefix || k[1] != nsVersion {
+		return "", 0, false
+	}
+	s := k[3:]
+	n := int(binary.BigEndian.Uint16(s))
+	if len(s) != 2+n+8 {
+		return "", 0, false
+	}
+	return string(s[2 : 2+n]), binary.BigEndian.Uint64(s[2+n:]), true
+}
+
+// entryKey is the key an index entry belongs to, which for version records
+// is the key they are a version of
+func entryKey(key string) string {
+	if len(key) > 1 && key[0] == sysPrefix && key[1] == nsVersion {
+		if k, _, ok := parseVersionKey([]byte(key)); ok {
+			return k
+		}
+	}
+	return key
+}
+
+// lastVersion returns the number of the newest version record of key, 0
+// when there is none
+func (c *Cask) lastVersion(key string) (uint64, error) {
+	iter := c.keys.NewIterator(util.BytesPrefix(versionPrefix(key)), nil)
+	defer iter.Release()
+	if !iter.Last() {
+		return 0, iter.Error()
+	}
+	_, v, _ := parseVersionKey(iter.Key())
+	return v, nil
+}
+
+// version numbers the entry act writes and retires the live entry it
+// replaces into a version record, old is the entry whether live or expired.
+// Versions are numbered from 1.
+func (c *Cask) version(act *action, old, live *HintLV) error {
+	last, err := c.lastVersion(act.key)
+	if err != nil {
+		return err
+	}
+	if live == nil {
+		if old != nil && old.Version > last {
+			last = old.Version
+		}
+		act.attrs.version = last + 1
+		return nil
+	}
+	v := live.Version
+	if v <= last {
+		// written while versioning was off
+		v = last + 1
+	}
+	live.Version, live.Replaced = v, act.attrs.modified
+	if act.attrs.retired, err = live.Bytes(); err != nil {
+		return err
+	}
+	act.attrs.retiredKey = versionKey(act.key, v)
+	act.attrs.version = v + 1
+	return nil
+}
+
+// retireDeleted adds to batch the version record of the live entry of key
+// being deleted, telling whether there was one
+func (c *Cask) retireDeleted(batch *leveldb.Batch, key string) (bool, error) {
+	d, err := c.keys.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	hlv, err := HintLVFromBytes(d)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if hlv.expired(now) {
+		return false, nil
+	}
+	act := &action{key: key, attrs: entryAttrs{modified: now.UnixNano()}}
+	if err = c.version(act, hlv, hlv); err != nil {
+		return false, err
+	}
+	batch.Put(act.attrs.retiredKey, act.attrs.retired)
+	c.journal(batch, string(act.attrs.retiredKey))
+	return true, nil
+}
+
+// doprune deletes version records along with the references they hold
+func (c *Cask) doprune(act *action) {
+	batch := new(leveldb.Batch)
+	for _, vk := range act.pruning {
+		batch.Delete([]byte(vk))
+		batch.Delete(sysKey(nsQuarantine, vk))
+		c.journal(batch, vk)
+	}
+	act.retvchan <- retv{err: c.commit(batch, act.pruning...)}
+}
+
+// PruneVersions drops the versions the retention policy keeps no more, their
+// values are reclaimed by the compaction that follows. Compact runs it first.
+func (m *mutcask) PruneVersions(ctx context.Context) error {
+	if m.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	if !m.cfg.versioned() {
+		// versions written before versioning was turned off are kept
+		return nil
+	}
+	cutoff := time.Now().Add(-m.cfg.VersionAge).UnixNano()
+	drops := make(map[uint32][]string)
+	var key string
+	var versions []string
+	var replaced []int64
+	flush := func() {
+		for i, vk := range versions {
+			// kept while one of the policies keeps it
+			if m.cfg.Versions > 0 && len(versions)-i <= m.cfg.Versions {
+				continue
+			}
+			if m.cfg.VersionAge > 0 && replaced[i] >= cutoff {
+				continue
+			}
+			id := m.fileID(key)
+			drops[id] = append(drops[id], vk)
+		}
+		versions, replaced = versions[:0], replaced[:0]
+	}
+	iter := m.keys.NewIterator(sysRange(nsVersion), nil)
+	for iter.Next() {
+		k, _, ok := parseVersionKey(iter.Key())
+		if !ok {
+			continue
+		}
+		if k != key {
+			flush()
+			key = k
+		}
+		hlv, err := HintLVFromBytes(iter.Value())
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		versions = append(versions, string(iter.Key()))
+		replaced = append(replaced, hlv.Replaced)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	flush()
+
+	n := 0
+	for id, vks := range drops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cask, has := m.caskMap.Get(id)
+		if !has {
+			continue
+		}
+		retvc := make(chan retv)
+		err := cask.submit(ctx, &action{
+			optype:   opprune,
+			pruning:  vks,
+			retvchan: retvc,
+		})
+		if err != nil {
+			return err
+		}
+		if ret := <-retvc; ret.err != nil {
+			return ret.err
+		}
+		n += len(vks)
+	}
+	if n > 0 {
+		m.cfg.Logger.Info("pruned versions", "versions", n)
+	}
+	return nil
+}
+
+// versionHolds tells whether a version of key points at the chunks of id
+func (m *mutcask) versionHolds(key string, id []byte) (bool, error) {
+	iter := m.keys.NewIterator(util.BytesPrefix(versionPrefix(key)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		hlv, err := HintLVFromBytes(iter.Value())
+		if err == nil && hlv.Chunks != nil && bytes.Equal(hlv.Chunks.ID, id) {
+			return true, nil
+		}
+	}
+	return false, iter.Error()
+}
+
+// VersionInfo describes a version of the value of a key
+type VersionInfo struct {
+	Version uint64
+	Size    int
+	// hex sha256 of the value, empty for values put before it was recorded
+	Checksum string
+	// when the version was written and when it was replaced or deleted,
+	// zero for the current one
+	Modified time.Time
+	Replaced time.Time
+}
+
+// ListVersions lists the versions of key kept, oldest first, ending with the
+// current value if the key is not deleted
+func (m *mutcask) ListVersions(key string) (vs []VersionInfo, err error) {
+	_, span := m.startOp("ListVersions", key)
+	defer func() { endSpan(span, err) }()
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	db, release := m.index()
+	defer release()
+	now := time.Now()
+	info := func(hlv *HintLV) VersionInfo {
+		vi := VersionInfo{
+			Version:  hlv.Version,
+			Size:     int(hlv.valueSize()),
+			Modified: unixTime(hlv.Modified),
+			Replaced: unixTime(hlv.Replaced),
+		}
+		if sum := hlv.checksum(); sum != nil {
+			vi.Checksum = hex.EncodeToString(sum)
+		}
+		if hlv.Digest != nil {
+			if rec, err := getDigest(db, hlv.Digest); err == nil {
+				vi.Size = int(rec.hintLV().valueSize())
+			}
+		}
+		return vi
+	}
+	iter := db.NewIterator(util.BytesPrefix(versionPrefix(key)), nil)
+	for iter.Next() {
+		hlv, err := HintLVFromBytes(iter.Value())
+		if err != nil {
+			iter.Release()
+			return nil, err
+		}
+		if !hlv.expired(now) {
+			vs = append(vs, info(hlv))
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	d, err := db.Get([]byte(key), nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		hlv, err := HintLVFromBytes(d)
+		if err != nil {
+			return nil, err
+		}
+		if !hlv.expired(now) {
+			vs = append(vs, info(hlv))
+		}
+	}
+	if len(vs) == 0 {
+		return nil, ErrNotFound
+	}
+	return vs, nil
+}
+
+// GetVersion returns version v of the value of key, which may be the current
+// one
+func (m *mutcask) GetVersion(key string, v uint64) (val []byte, err error) {
+	ctx, span := m.startOp("GetVersion", key)
+	defer func() { endSpan(span, err) }()
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	vkey := string(versionKey(key, v))
+	val, err = m.getValue(ctx, vkey)
+	if err != ErrNotFound {
+		return val, err
+	}
+	hint, val, err := m.getHinted(ctx, key)
+	switch {
+	case err == ErrNotFound:
+		return nil, ErrNotFound
+	case err != nil:
+		return nil, err
+	case hint.Version == v:
+		return val, nil
+	case hint.Version > v:
+		// v was replaced after its record was looked for
+		return m.getValue(ctx, vkey)
+	}
+	return nil, ErrNotFound
+}
+
+// GetAt returns the value key held at t, ErrNotFound when it had none or the
+// version is not kept anymore
+func (m *mutcask) GetAt(key string, t time.Time) ([]byte, error) {
+	vs, err := m.ListVersions(key)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(vs) - 1; i >= 0; i-- {
+		vi := vs[i]
+		if vi.Modified.After(t) {
+			continue
+		}
+		if !vi.Replaced.IsZero() && !vi.Replaced.After(t) {
+			// deleted by t
+			break
+		}
+		return m.GetVersion(key, vi.Version)
+	}
+	return nil, ErrNotFound
+}
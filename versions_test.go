@@ -0,0 +1,327 @@
+package mutcask
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVersions(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(2), ChunkConf(100), VersioningConf(2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	value := func(i int) []byte {
+		if i%2 == 0 {
+			// split into chunks
+			return bytes.Repeat([]byte(fmt.Sprintf("version %d ", i)), 30)
+		}
+		return []byte(fmt.Sprintf("version %d", i))
+	}
+	for i := 1; i <= 4; i++ {
+		if err := m.Put("doc", value(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs, err := m.ListVersions("doc")
+	if err != nil || len(vs) != 4 {
+		t.Fatalf("versions %+v: %v", vs, err)
+	}
+	for i, vi := range vs {
+		if vi.Version != uint64(i+1) || vi.Size != len(value(i+1)) {
+			t.Fatalf("version %+v", vi)
+		}
+		if i < 3 && vi.Replaced.IsZero() || i == 3 && !vi.Replaced.IsZero() {
+			t.Fatalf("replaced %+v", vi)
+		}
+		v, err := m.GetVersion("doc", vi.Version)
+		if err != nil || !bytes.Equal(v, value(i+1)) {
+			t.Fatalf("get version %d %q: %v", vi.Version, v, err)
+		}
+	}
+	if st, _ := m.Stat("doc"); st.Version != 4 {
+		t.Fatalf("stat %+v", st)
+	}
+
+	// undo a delete
+	if err := m.Delete("doc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("doc"); err != ErrNotFound {
+		t.Fatalf("get deleted: %v", err)
+	}
+	v, err := m.GetVersion("doc", 4)
+	if err != nil || !bytes.Equal(v, value(4)) {
+		t.Fatalf("get deleted version: %v", err)
+	}
+	if err := m.Put("doc", v); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := m.Stat("doc"); st.Version != 5 {
+		t.Fatalf("stat after undo %+v", st)
+	}
+
+	// compaction keeps the last two versions and what they point at
+	if err := m.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	vs, err = m.ListVersions("doc")
+	if err != nil || len(vs) != 3 || vs[0].Version != 3 {
+		t.Fatalf("versions after compaction %+v: %v", vs, err)
+	}
+	if _, err := m.GetVersion("doc", 2); err != ErrNotFound {
+		t.Fatalf("get pruned version: %v", err)
+	}
+	for _, i := range []int{3, 4, 5} {
+		want := value(i)
+		if i == 5 {
+			want = value(4)
+		}
+		if v, err := m.GetVersion("doc", uint64(i)); err != nil || !bytes.Equal(v, want) {
+			t.Fatalf("get version %d after compaction: %v", i, err)
+		}
+	}
+	chunks := 0
+	iter := m.keys.NewIterator(sysRange(nsChunk), nil)
+	for iter.Next() {
+		chunks++
+	}
+	iter.Release()
+	if chunks != 6 {
+		t.Fatalf("%d chunks left for versions 4 and 5", chunks)
+	}
+	st, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.VLogBytes != st.LiveBytes {
+		t.Fatalf("not reclaimed %+v", st)
+	}
+}
+
+func TestVersionsIncrementalBackup(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(2), ChunkConf(100), VersioningConf(2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	restored := filepath.Join(tmpdirpath(t), "restored")
+	defer os.RemoveAll(filepath.Dir(restored))
+
+	value := func(i int) []byte {
+		if i%2 == 0 {
+			return bytes.Repeat([]byte(fmt.Sprintf("version %d ", i)), 30)
+		}
+		return []byte(fmt.Sprintf("version %d", i))
+	}
+	since := Manifest{}
+	for i, step := range []func() error{
+		func() error {
+			for i := 1; i <= 2; i++ {
+				if err := m.Put("doc", value(i)); err != nil {
+					return err
+				}
+			}
+			return m.Put("other", value(1))
+		},
+		// versions of values stored by earlier backups
+		func() error {
+			if err := m.Put("doc", value(3)); err != nil {
+				return err
+			}
+			return m.Delete("other")
+		},
+		// pruned versions go on restore as well
+		func() error {
+			if err := m.Put("doc", value(4)); err != nil {
+				return err
+			}
+			return m.PruneVersions(context.Background())
+		},
+		func() error {
+			if err := m.Compact(context.Background()); err != nil {
+				return err
+			}
+			return m.Put("doc", value(5))
+		},
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if since, err = m.IncrementalBackup(since, &b); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := RestoreBackups(restored, &b); err != nil {
+			t.Fatalf("restore backup %d: %v", i, err)
+		}
+		r, err := NewMutcask(PathConf(restored), CaskNumConf(2), ChunkConf(100), VersioningConf(2, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"doc", "other"} {
+			want, _ := m.ListVersions(k)
+			vs, _ := r.ListVersions(k)
+			if len(vs) != len(want) {
+				t.Fatalf("backup %d restored versions of %s %+v, want %+v", i, k, vs, want)
+			}
+			for j, vi := range vs {
+				if vi != want[j] {
+					t.Fatalf("backup %d restored version %+v, want %+v", i, vi, want[j])
+				}
+				v, _ := m.GetVersion(k, vi.Version)
+				if rv, err := r.GetVersion(k, vi.Version); err != nil || !bytes.Equal(rv, v) {
+					t.Fatalf("backup %d restored version %d of %s: %v", i, vi.Version, k, err)
+				}
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestVersionAge(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(1), DedupConf(), VersioningConf(0, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// versions of a shared value hold a reference of their own
+	shared := []byte("shared by two keys")
+	for _, k := range []string{"a", "b"} {
+		if err := m.Put(k, shared); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Put("a", []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b"} {
+		if v, err := m.GetVersion(k, 1); err != nil || !bytes.Equal(v, shared) {
+			t.Fatalf("get version of %s %q: %v", k, v, err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if err := m.Compact(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ListVersions("b"); err != ErrNotFound {
+		t.Fatalf("versions of b: %v", err)
+	}
+	if vs, err := m.ListVersions("a"); err != nil || len(vs) != 1 || vs[0].Version != 2 {
+		t.Fatalf("versions of a %+v: %v", vs, err)
+	}
+	st, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.VLogBytes != st.LiveBytes {
+		t.Fatalf("not reclaimed %+v", st)
+	}
+}
+
+func TestGetAt(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(1), VersioningConf(10, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	tick := func() time.Time {
+		time.Sleep(2 * time.Millisecond)
+		now := time.Now()
+		time.Sleep(2 * time.Millisecond)
+		return now
+	}
+	before := tick()
+	if err := m.Put("doc", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	first := tick()
+	if err := m.Put("doc", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	second := tick()
+	if err := m.Delete("doc"); err != nil {
+		t.Fatal(err)
+	}
+	deleted := tick()
+	if err := m.Put("doc", []byte("third")); err != nil {
+		t.Fatal(err)
+	}
+	for at, want := range map[time.Time]string{first: "first", second: "second", time.Now(): "third"} {
+		if v, err := m.GetAt("doc", at); err != nil || string(v) != want {
+			t.Fatalf("get at %v %q: %v, expected %q", at, v, err, want)
+		}
+	}
+	for _, at := range []time.Time{before, deleted} {
+		if _, err := m.GetAt("doc", at); err != ErrNotFound {
+			t.Fatalf("get at %v: %v", at, err)
+		}
+	}
+}
+
+func TestGetVersionRacingPut(t *testing.T) {
+	dir := tmpdirpath(t)
+	defer os.RemoveAll(dir)
+	m, err := NewMutcask(PathConf(dir), CaskNumConf(1), VersioningConf(1000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	const n = 200
+	if err := m.Put("doc", []byte("version 1")); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		for i := 2; i <= n; i++ {
+			if err := m.Put("doc", []byte(fmt.Sprintf("version %d", i))); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		default:
+		}
+		st, err := m.Stat("doc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the version read is the one asked for, even when replaced meanwhile
+		v, err := m.GetVersion("doc", st.Version)
+		if err != nil || string(v) != fmt.Sprintf("version %d", st.Version) {
+			t.Fatalf("get version %d %q: %v", st.Version, v, err)
+		}
+	}
+}